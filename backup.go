@@ -0,0 +1,212 @@
+package sophia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// BackupPath is a path of the directory where sophia writes backups.
+// It should be set before Environment's Open() to enable backups.
+const BackupPath = "backup.path"
+
+const (
+	keyBackupRun          = "backup.run"
+	keyBackupActive       = "backup.active"
+	keyBackupLast         = "backup.last"
+	keyBackupLastComplete = "backup.last_complete"
+	keyBSN                = "metric.bsn"
+	keyDatabaseBackup     = "db.%v.scheduler.backup"
+)
+
+// backupPollInterval is an interval of backup status checks
+const backupPollInterval = 10 * time.Millisecond
+
+// ErrBackupDisabled is returned by Backup when BackupPath has not been configured
+var ErrBackupDisabled = errors.New("backup is not enabled")
+
+// BackupProgress describes state of running backup
+type BackupProgress struct {
+	// BSN is a backup sequence number
+	BSN int64
+	// DatabasesDone is a number of databases which files have been copied
+	DatabasesDone int
+	// DatabasesTotal is a number of databases in backup
+	DatabasesTotal int
+}
+
+// Backup runs online backup of the environment and copies it to dir
+// which should not exist. Writers aren't blocked during backup.
+// Returns backup sequence number of created backup.
+// See BackupWithProgress for details.
+func (env *Environment) Backup(ctx context.Context, dir string) (int64, error) {
+	return env.BackupWithProgress(ctx, dir, nil)
+}
+
+// BackupWithProgress runs online backup of the environment and copies it to dir
+// which should not exist. Writers aren't blocked during backup.
+// progress, if it is not nil, is called periodically while backup is running.
+// Backup is written by sophia into <BackupPath>/<bsn> directory first
+// and then it's moved into dir. dir contains a directory per database and log directory.
+// Cancellation of ctx stops waiting, but sophia can't interrupt started backup,
+// so it will be finished in background and left in BackupPath.
+// If another backup is already running, it is waited for instead of starting new one.
+// In manual scheduler mode backup is run by the scheduler in the calling goroutine.
+// ComparatorName of databases is copied into backup with database files.
+// Returns backup sequence number of created backup.
+func (env *Environment) BackupWithProgress(ctx context.Context, dir string, progress func(BackupProgress)) (int64, error) {
+	if env.ptr == nil {
+		return 0, ErrEnvironmentClosed
+	}
+	backupPath := env.configString(BackupPath)
+	if backupPath == "" {
+		return 0, ErrBackupDisabled
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return 0, fmt.Errorf("backup directory '%v' already exists", dir)
+	}
+
+	if !env.SetInt(keyBackupRun, 0) {
+		return 0, fmt.Errorf("failed to start backup: %v", env.Error())
+	}
+	bsn := env.GetInt(keyBSN)
+	manual := env.GetInt(keySchedulerThreads) == 0
+
+	ticker := time.NewTicker(backupPollInterval)
+	defer ticker.Stop()
+	for env.GetInt(keyBackupActive) != 0 {
+		if progress != nil {
+			progress(env.backupProgress(bsn))
+		}
+		if manual {
+			// there are no scheduler threads, which would run backup
+			switch spCall(env.ptr, getCStringFromCache(keySchedulerRun)) {
+			case -1:
+				return bsn, fmt.Errorf("failed to run backup: %v", env.Error())
+			case 0:
+			default:
+				if err := ctx.Err(); err != nil {
+					return bsn, err
+				}
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return bsn, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	if env.GetInt(keyBackupLast) != bsn || env.GetInt(keyBackupLastComplete) == 0 {
+		return bsn, fmt.Errorf("backup %v failed: %v", bsn, env.Error())
+	}
+	databases := env.Databases()
+	if progress != nil {
+		progress(BackupProgress{
+			BSN:            bsn,
+			DatabasesDone:  len(databases),
+			DatabasesTotal: len(databases),
+		})
+	}
+
+	src := filepath.Join(backupPath, strconv.FormatInt(bsn, 10))
+	if err := moveDir(src, dir); err != nil {
+		return bsn, fmt.Errorf("failed to move backup %v: %v", bsn, err)
+	}
+	// sophia doesn't know about comparator file, so it's copied separately
+	for _, db := range databases {
+		if db.comparatorName == "" {
+			continue
+		}
+		err := copyFile(filepath.Join(env.databasePath(db.name), comparatorFile),
+			filepath.Join(dir, db.name, comparatorFile), 0644)
+		if err != nil {
+			return bsn, fmt.Errorf("failed to copy comparator of database '%v': %v", db.name, err)
+		}
+	}
+	return bsn, nil
+}
+
+// backupProgress collects progress of running backup
+func (env *Environment) backupProgress(bsn int64) BackupProgress {
+	databases := env.Databases()
+	progress := BackupProgress{
+		BSN:            bsn,
+		DatabasesTotal: len(databases),
+	}
+	for _, db := range databases {
+		if env.GetInt(fmt.Sprintf(keyDatabaseBackup, db.name)) == 0 {
+			progress.DatabasesDone++
+		}
+	}
+	return progress
+}
+
+// configString returns string value of environment configuration
+func (env *Environment) configString(path string) string {
+	var size int
+	ptr := spGetString(env.ptr, getCStringFromCache(path), &size)
+	if ptr == nil {
+		return ""
+	}
+	defer free(ptr)
+	return goString(ptr)
+}
+
+// moveDir moves directory src to dst, it falls back to copying
+// when directories are located on different file systems
+func moveDir(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyDir(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyDir recursively copies directory src to dst
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// copyFile copies regular file src to dst
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,138 @@
+package sophia
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentBackup(t *testing.T) {
+	const (
+		keyPath      = "key"
+		valuePath    = "value"
+		recordsCount = 100
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, filepath.Join(tmpDir, "data")))
+	require.True(t, env.SetString(BackupPath, filepath.Join(tmpDir, "backup")))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	for i := 0; i < recordsCount; i++ {
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, fmt.Sprintf("key%v", i)))
+		require.True(t, doc.Set(valuePath, fmt.Sprintf("value%v", i)))
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+	}
+
+	var last BackupProgress
+	dir := filepath.Join(tmpDir, "result")
+	bsn, err := env.BackupWithProgress(context.Background(), dir, func(p BackupProgress) {
+		last = p
+	})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), bsn)
+	require.Equal(t, BackupProgress{BSN: bsn, DatabasesDone: 1, DatabasesTotal: 1}, last)
+
+	_, err = os.Stat(filepath.Join(dir, "test_database", "scheme"))
+	require.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "log"))
+	require.Nil(t, err)
+
+	_, err = env.Backup(context.Background(), dir)
+	require.NotNil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bsn, err = env.Backup(ctx, filepath.Join(tmpDir, "cancelled"))
+	require.Equal(t, int64(2), bsn)
+	if err != nil {
+		require.Equal(t, context.Canceled, err)
+	}
+	require.Eventually(t, func() bool {
+		return env.GetInt(keyBackupActive) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestEnvironmentBackupManualScheduler(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, filepath.Join(tmpDir, "data")))
+	require.True(t, env.SetString(BackupPath, filepath.Join(tmpDir, "backup")))
+	require.Nil(t, env.SetSchedulerConfig(SchedulerConfig{Manual: true}))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	doc := db.Document()
+	require.True(t, doc.Set("key", "key"))
+	require.True(t, doc.Set("value", "value"))
+	require.Nil(t, db.Set(doc))
+	doc.Free()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dir := filepath.Join(tmpDir, "result")
+	bsn, err := env.Backup(ctx, dir)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), bsn)
+
+	_, err = os.Stat(filepath.Join(dir, "test_database", "scheme"))
+	require.Nil(t, err)
+}
+
+func TestEnvironmentBackupDisabled(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	_, err = env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	_, err = env.Backup(context.Background(), filepath.Join(tmpDir, "result"))
+	require.Equal(t, ErrBackupDisabled, err)
+}
@@ -0,0 +1,232 @@
+package sophia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrBatchFinished is returned on usage of a committed or rollbacked Batch
+	ErrBatchFinished = errors.New("usage of finished batch")
+	// ErrBatchRollback is returned when a batch chunk has been rollbacked by a concurrent transaction
+	ErrBatchRollback = errors.New("batch chunk rollbacked by concurrent transaction")
+)
+
+// BatchOptions limits size of batch chunks.
+// Chunk is committed as soon as any of limits is reached, zero value means no limit.
+type BatchOptions struct {
+	// MaxDocuments is a maximum number of writes in a chunk
+	MaxDocuments int
+	// MaxBytes is a maximum total size of written fields values in a chunk
+	MaxBytes int
+}
+
+// DefaultBatchOptions is used by batches unless another options are set by SetBatchOptions
+var DefaultBatchOptions = BatchOptions{
+	MaxDocuments: 10000,
+	MaxBytes:     64 << 20,
+}
+
+// BatchChunk describes a part of batch committed in a single transaction
+type BatchChunk struct {
+	Documents int
+	Bytes     int
+	Status    TxStatus
+}
+
+// Batch accumulates writes to databases of an environment and commits them in transactions.
+// Writes are split into chunks according to BatchOptions, every chunk is committed
+// in a separate transaction, so a batch is atomic only if it fits into a single chunk.
+// If a chunk fails to be written or committed, it is rollbacked and following writes return the error.
+// Commit() or Rollback() should be called to release resources.
+type Batch struct {
+	env     *Environment
+	ctx     context.Context
+	options BatchOptions
+	tx      *Transaction
+	chunk   BatchChunk
+	// upserts contains keys upserted in the current chunk
+	upserts  map[string]struct{}
+	chunks   []BatchChunk
+	err      error
+	finished bool
+}
+
+// SetBatchOptions sets limits of chunks for batches created after the call.
+// It can be called concurrently with creation of batches.
+func (env *Environment) SetBatchOptions(options BatchOptions) {
+	env.optionsMu.Lock()
+	env.batchOptions = options
+	env.optionsMu.Unlock()
+}
+
+// NewBatch creates a Batch. It can contain writes to any database of the environment.
+func (db *Database) NewBatch() *Batch {
+	return db.env.newBatch(context.Background())
+}
+
+// NewBatchContext is like NewBatch, but commits of chunks are stopped with ctx error when ctx is done.
+func (db *Database) NewBatchContext(ctx context.Context) *Batch {
+	return db.env.newBatch(ctx)
+}
+
+// Batch executes fn with a new Batch and commits it.
+// If fn returns an error, the current chunk is rollbacked and the error is returned,
+// chunks committed before stay committed.
+// fn must not call Commit() or Rollback() of the batch.
+// Returned chunks describe transactions, which have been committed or failed.
+func (env *Environment) Batch(fn func(b *Batch) error) ([]BatchChunk, error) {
+	return env.BatchContext(context.Background(), fn)
+}
+
+// BatchContext is like Batch, but commits of chunks are stopped with ctx error when ctx is done.
+func (env *Environment) BatchContext(ctx context.Context, fn func(b *Batch) error) ([]BatchChunk, error) {
+	if env.ptr == nil {
+		return nil, ErrEnvironmentClosed
+	}
+	b := env.newBatch(ctx)
+	if err := fn(b); err != nil {
+		b.Rollback()
+		return b.Chunks(), err
+	}
+	err := b.Commit()
+	return b.Chunks(), err
+}
+
+func (env *Environment) newBatch(ctx context.Context) *Batch {
+	env.optionsMu.RLock()
+	defer env.optionsMu.RUnlock()
+	return &Batch{
+		env:     env,
+		ctx:     ctx,
+		options: env.batchOptions,
+	}
+}
+
+// Set adds the row of the set of keys to the batch.
+func (b *Batch) Set(doc Document) error {
+	return b.write(doc, b.documentSize(doc), (*Transaction).Set)
+}
+
+// Upsert adds upsert of the row to the batch.
+// Sophia allows only one upsert of a key per transaction,
+// so the current chunk is committed before a repeated upsert of a key.
+func (b *Batch) Upsert(doc Document) error {
+	key := doc.key()
+	if _, ok := b.upserts[key]; ok && !b.finished && b.err == nil {
+		if err := b.flush(); err != nil {
+			doc.discard("it has been passed to Batch")
+			return err
+		}
+	}
+	return b.write(doc, b.documentSize(doc), func(tx *Transaction, doc Document) error {
+		if err := tx.Upsert(doc); err != nil {
+			return err
+		}
+		if b.upserts == nil {
+			b.upserts = make(map[string]struct{})
+		}
+		b.upserts[key] = struct{}{}
+		return nil
+	})
+}
+
+// Delete adds deletion of the row with specified set of keys to the batch.
+func (b *Batch) Delete(doc Document) error {
+	return b.write(doc, b.documentSize(doc), (*Transaction).Delete)
+}
+
+// Commit commits the last chunk of the batch.
+func (b *Batch) Commit() error {
+	if b.finished {
+		return ErrBatchFinished
+	}
+	b.finished = true
+	if b.err != nil || b.tx == nil {
+		return b.err
+	}
+	return b.flush()
+}
+
+// Rollback discards writes of the current chunk, previous chunks stay committed.
+func (b *Batch) Rollback() error {
+	if b.finished {
+		return ErrBatchFinished
+	}
+	b.finished = true
+	return b.rollback()
+}
+
+// Chunks returns chunks committed or failed to commit so far
+func (b *Batch) Chunks() []BatchChunk {
+	return append([]BatchChunk(nil), b.chunks...)
+}
+
+// documentSize returns size of the document, if it is limited by options
+func (b *Batch) documentSize(doc Document) int {
+	if b.options.MaxBytes > 0 {
+		return doc.size()
+	}
+	return 0
+}
+
+// write writes document of given size to the current chunk and commits the chunk if it is full
+func (b *Batch) write(doc Document, size int, write func(*Transaction, Document) error) error {
+	var err error
+	switch {
+	case b.finished:
+		err = ErrBatchFinished
+	case b.err != nil:
+		err = b.err
+	case b.ctx.Err() != nil:
+		err = b.ctx.Err()
+	case b.tx == nil:
+		b.tx, err = b.env.BeginTx()
+	}
+	if err != nil {
+		doc.discard("it has been passed to Batch")
+		return err
+	}
+	if err := write(b.tx, doc); err != nil {
+		b.rollback()
+		b.err = fmt.Errorf("failed to write batch chunk #%v: %w", len(b.chunks)+1, err)
+		return b.err
+	}
+	b.chunk.Documents++
+	b.chunk.Bytes += size
+	if b.options.MaxDocuments > 0 && b.chunk.Documents >= b.options.MaxDocuments ||
+		b.options.MaxBytes > 0 && b.chunk.Bytes >= b.options.MaxBytes {
+		return b.flush()
+	}
+	return nil
+}
+
+// rollback discards writes of the current chunk
+func (b *Batch) rollback() error {
+	tx := b.tx
+	b.tx, b.chunk, b.upserts = nil, BatchChunk{}, nil
+	if tx == nil {
+		return nil
+	}
+	return tx.Rollback()
+}
+
+// flush commits the current chunk
+func (b *Batch) flush() error {
+	tx, chunk := b.tx, b.chunk
+	b.tx, b.chunk, b.upserts = nil, BatchChunk{}, nil
+	status, err := b.env.commit(b.ctx, tx, b.env.loadRetryPolicy())
+	if !tx.finished {
+		tx.Rollback()
+	}
+	if err == nil && status == TxRollback {
+		err = ErrBatchRollback
+	}
+	chunk.Status = status
+	b.chunks = append(b.chunks, chunk)
+	if err != nil {
+		b.err = fmt.Errorf("failed to commit batch chunk #%v: %w", len(b.chunks), err)
+	}
+	return b.err
+}
@@ -0,0 +1,242 @@
+package sophia
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch(t *testing.T) {
+	const (
+		keyPath   = "key"
+		valuePath = "value"
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(keyPath, FieldTypeUInt64))
+	require.Nil(t, schema.AddValue(valuePath, FieldTypeString))
+
+	db1, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database1",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+	db2, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database2",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	set := func(b *Batch, db *Database, key uint64, value string) error {
+		doc := db.Document()
+		defer doc.Free()
+		require.Nil(t, doc.SetUint64(keyPath, key))
+		require.Nil(t, doc.SetBytes(valuePath, []byte(value)))
+		return b.Set(doc)
+	}
+	count := func(db *Database) int {
+		cursor, err := db.Cursor(db.Document())
+		require.Nil(t, err)
+		defer cursor.Close()
+		var n int
+		for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+			n++
+		}
+		return n
+	}
+
+	t.Run("MaxDocuments", func(t *testing.T) {
+		env.SetBatchOptions(BatchOptions{MaxDocuments: 10})
+		chunks, err := env.Batch(func(b *Batch) error {
+			for i := uint64(0); i < 25; i++ {
+				require.Nil(t, set(b, db1, i, "value"))
+				require.Nil(t, set(b, db2, i, "value"))
+			}
+			doc := db2.Document()
+			defer doc.Free()
+			require.Nil(t, doc.SetUint64(keyPath, 0))
+			return b.Delete(doc)
+		})
+		require.Nil(t, err)
+		require.Equal(t, []BatchChunk{
+			{Documents: 10, Status: TxOk},
+			{Documents: 10, Status: TxOk},
+			{Documents: 10, Status: TxOk},
+			{Documents: 10, Status: TxOk},
+			{Documents: 10, Status: TxOk},
+			{Documents: 1, Status: TxOk},
+		}, chunks)
+		require.Equal(t, 25, count(db1))
+		require.Equal(t, 24, count(db2))
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		env.SetBatchOptions(BatchOptions{MaxBytes: 1000})
+		b := db1.NewBatch()
+		value := strings.Repeat("a", 92)
+		for i := uint64(100); i < 125; i++ {
+			require.Nil(t, set(b, db1, i, value))
+		}
+		require.Nil(t, b.Commit())
+		require.Equal(t, []BatchChunk{
+			{Documents: 10, Bytes: 1000, Status: TxOk},
+			{Documents: 10, Bytes: 1000, Status: TxOk},
+			{Documents: 5, Bytes: 500, Status: TxOk},
+		}, b.Chunks())
+		require.Equal(t, 50, count(db1))
+
+		require.Equal(t, ErrBatchFinished, b.Commit())
+		require.Equal(t, ErrBatchFinished, set(b, db1, 0, value))
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		env.SetBatchOptions(BatchOptions{MaxDocuments: 10})
+		expectedErr := errors.New("expected error")
+		chunks, err := env.Batch(func(b *Batch) error {
+			for i := uint64(200); i < 215; i++ {
+				require.Nil(t, set(b, db1, i, "value"))
+			}
+			return expectedErr
+		})
+		require.Equal(t, expectedErr, err)
+		require.Equal(t, []BatchChunk{{Documents: 10, Status: TxOk}}, chunks)
+		require.Equal(t, 60, count(db1))
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		env.SetBatchOptions(BatchOptions{MaxDocuments: 2})
+		env.SetRetryPolicy(RetryPolicy{Backoff: time.Millisecond, LockTimeout: 10 * time.Millisecond})
+		defer env.SetRetryPolicy(DefaultRetryPolicy)
+
+		tx, err := env.BeginTx()
+		require.Nil(t, err)
+		doc := db1.Document()
+		require.Nil(t, doc.SetUint64(keyPath, 301))
+		require.Nil(t, tx.Set(doc))
+		doc.Free()
+
+		b := db1.NewBatch()
+		require.Nil(t, set(b, db1, 300, "value"))
+		err = set(b, db1, 301, "value")
+		require.True(t, errors.Is(err, ErrTxLockTimeout))
+		require.Equal(t, err, set(b, db1, 302, "value"))
+		require.Equal(t, err, b.Commit())
+		require.Equal(t, []BatchChunk{{Documents: 2, Status: TxLock}}, b.Chunks())
+		require.Equal(t, TxOk, tx.Commit())
+		require.Equal(t, 61, count(db1))
+	})
+
+	t.Run("Write error", func(t *testing.T) {
+		env.SetBatchOptions(BatchOptions{MaxDocuments: 10})
+		b := db1.NewBatch()
+		require.Nil(t, set(b, db1, 400, "value"))
+		doc := db1.Document()
+		err := b.Set(doc)
+		doc.Free()
+		require.NotNil(t, err)
+		require.Equal(t, err, set(b, db1, 401, "value"))
+		require.Equal(t, err, b.Commit())
+		require.Empty(t, b.Chunks())
+		require.Equal(t, 61, count(db1))
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		env.SetBatchOptions(BatchOptions{MaxDocuments: 2})
+		ctx, cancel := context.WithCancel(context.Background())
+		chunks, err := env.BatchContext(ctx, func(b *Batch) error {
+			require.Nil(t, set(b, db1, 500, "value"))
+			require.Nil(t, set(b, db1, 501, "value"))
+			cancel()
+			return set(b, db1, 502, "value")
+		})
+		require.True(t, errors.Is(err, context.Canceled))
+		require.Equal(t, []BatchChunk{{Documents: 2, Status: TxOk}}, chunks)
+		require.Equal(t, 63, count(db1))
+
+		chunks, err = env.BatchContext(ctx, func(b *Batch) error {
+			return nil
+		})
+		require.Nil(t, err)
+		require.Empty(t, chunks)
+	})
+}
+
+func TestBatchUpsert(t *testing.T) {
+	const (
+		keyPath  = "key"
+		hitsPath = "hits"
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(keyPath, FieldTypeUInt32))
+	require.Nil(t, schema.AddValue(hitsPath, FieldTypeUInt64))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:      "test_database",
+		Schema:    schema,
+		UpsertOps: UpsertOps{hitsPath: Add},
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	chunks, err := env.Batch(func(b *Batch) error {
+		for _, key := range []uint32{1, 2, 1, 1} {
+			doc := db.Document()
+			require.Nil(t, doc.SetUint32(keyPath, key))
+			require.Nil(t, doc.SetUint64(hitsPath, 1))
+			err := b.Upsert(doc)
+			doc.Free()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, []BatchChunk{
+		{Documents: 2, Bytes: 24, Status: TxOk},
+		{Documents: 1, Bytes: 12, Status: TxOk},
+		{Documents: 1, Bytes: 12, Status: TxOk},
+	}, chunks)
+
+	for key, expected := range map[uint32]uint64{1: 3, 2: 1} {
+		doc := db.Document()
+		require.Nil(t, doc.SetUint32(keyPath, key))
+		d, err := db.Get(doc)
+		doc.Free()
+		require.Nil(t, err)
+		hits, err := d.GetUint64(hitsPath)
+		d.Destroy()
+		require.Nil(t, err)
+		require.Equal(t, expected, hits)
+	}
+}
@@ -0,0 +1,203 @@
+package sophia
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unsafe"
+)
+
+// ErrBulkLoadOrder is returned by BulkLoad if keys of rows aren't in strictly ascending order
+var ErrBulkLoadOrder = errors.New("bulk load keys aren't in ascending order")
+
+// RowReader is a source of rows for BulkLoad
+type RowReader interface {
+	// ReadRow sets values of the next row to row.
+	// It returns io.EOF when there are no more rows.
+	ReadRow(row Row) error
+}
+
+// RowReaderFunc is an adapter to use an ordinary function as RowReader
+type RowReaderFunc func(row Row) error
+
+// ReadRow calls f(row)
+func (f RowReaderFunc) ReadRow(row Row) error {
+	return f(row)
+}
+
+// BulkLoadStats describes rows committed by BulkLoad
+type BulkLoadStats struct {
+	Rows     int
+	Bytes    int
+	Chunks   int
+	Duration time.Duration
+}
+
+// RowsPerSecond returns throughput of the load in rows
+func (s BulkLoadStats) RowsPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Rows) / s.Duration.Seconds()
+}
+
+// BytesPerSecond returns throughput of the load in bytes of fields values
+func (s BulkLoadStats) BytesPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+func (s BulkLoadStats) String() string {
+	return fmt.Sprintf("%v rows (%v bytes) in %v chunks for %v: %.0f rows/s, %.0f bytes/s",
+		s.Rows, s.Bytes, s.Chunks, s.Duration, s.RowsPerSecond(), s.BytesPerSecond())
+}
+
+// BulkLoad writes rows read from r to the database.
+// It is intended for the initial load of pre-sorted data: keys must be in strictly ascending
+// order of the database, otherwise loading is stopped with ErrBulkLoadOrder.
+// Rows are committed in chunks as a Batch, limits of chunks are set by SetBatchOptions.
+// The same Row is passed to every ReadRow call, its values are kept in C buffers, which are reused,
+// so memory isn't allocated per field value. A document is still created per row, because
+// sophia consumes documents passed to writes, so a single one can't be reused.
+// If loading is stopped by an error, chunks committed before stay committed, stats describe them.
+func (db *Database) BulkLoad(r RowReader) (BulkLoadStats, error) {
+	start := time.Now()
+	b := db.NewBatch()
+	err := db.bulkLoad(r, b)
+	if err == nil {
+		err = b.Commit()
+	} else {
+		b.Rollback()
+	}
+
+	stats := BulkLoadStats{Duration: time.Since(start)}
+	for _, chunk := range b.chunks {
+		stats.Chunks++
+		if chunk.Status == TxOk {
+			stats.Rows += chunk.Documents
+			stats.Bytes += chunk.Bytes
+		}
+	}
+	return stats, err
+}
+
+func (db *Database) bulkLoad(r RowReader, b *Batch) error {
+	buffers := newRowBuffers(db.fieldsCount)
+	defer buffers.free()
+	row := Row{
+		db:      db,
+		fields:  make([][]byte, db.fieldsCount),
+		buffers: buffers,
+	}
+	fields := db.schema.scheme()
+	paths := make([]*C.char, len(fields))
+	for i, field := range fields {
+		paths[i] = getCStringFromCache(field.name)
+	}
+	prev := make([][]byte, len(db.schema.keysNames))
+
+	for n := 0; ; n++ {
+		for i := range row.fields {
+			row.fields[i] = nil
+		}
+		if err := r.ReadRow(row); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := db.checkBulkLoadKey(row, prev, n); err != nil {
+			return err
+		}
+
+		ptr := spDocument(db.ptr)
+		if ptr == nil {
+			return fmt.Errorf("failed to create document: %v", db.env.Error())
+		}
+		var size int
+		for i, value := range row.fields {
+			if value == nil {
+				continue
+			}
+			if !spSetString(ptr, paths[i], buffers.ptrs[i], len(value)) {
+				spDestroy(ptr)
+				return fmt.Errorf("failed to set field '%v' of row #%v: %v", fields[i].name, n, db.env.Error())
+			}
+			size += len(value)
+		}
+		doc := Document{varStore: newVarStore(ptr, 0), db: db}
+		if err := b.write(doc, size, (*Transaction).Set); err != nil {
+			return err
+		}
+
+		for i, name := range db.schema.keysNames {
+			prev[i] = append(prev[i][:0], row.fields[db.positions[name]]...)
+		}
+	}
+}
+
+// checkBulkLoadKey checks that key of row #n is set and greater than the previous one
+func (db *Database) checkBulkLoadKey(row Row, prev [][]byte, n int) error {
+	c := 0
+	for i, name := range db.schema.keysNames {
+		value := row.fields[db.positions[name]]
+		if value == nil {
+			return fmt.Errorf("key '%v' of row #%v isn't set", name, n)
+		}
+		if c == 0 && n > 0 {
+			c = db.compareField(db.schema.keys[name], value, prev[i])
+		}
+	}
+	if n > 0 && c <= 0 {
+		return fmt.Errorf("%w: row #%v", ErrBulkLoadOrder, n)
+	}
+	return nil
+}
+
+// rowBuffers keeps C memory of row fields values, which is reused between rows
+type rowBuffers struct {
+	ptrs []unsafe.Pointer
+	caps []int
+}
+
+func newRowBuffers(count int) *rowBuffers {
+	return &rowBuffers{
+		ptrs: make([]unsafe.Pointer, count),
+		caps: make([]int, count),
+	}
+}
+
+// get returns buffer of given size for field at pos, it is valid until the next get of the field.
+// Buffer is followed by zero byte, because sophia calculates size of zero-sized field with strlen.
+func (b *rowBuffers) get(pos, size int) ([]byte, error) {
+	if b.caps[pos] < size+1 {
+		capacity := max(size+1, 2*b.caps[pos])
+		ptr := C.realloc(b.ptrs[pos], C.size_t(capacity))
+		if ptr == nil {
+			return nil, fmt.Errorf("failed to allocate %v bytes for field value", capacity)
+		}
+		b.ptrs[pos] = ptr
+		b.caps[pos] = capacity
+	}
+	buf := unsafe.Slice((*byte)(b.ptrs[pos]), size+1)
+	buf[size] = 0
+	return buf[:size:size], nil
+}
+
+func (b *rowBuffers) free() {
+	for i, ptr := range b.ptrs {
+		if ptr != nil {
+			C.free(ptr)
+		}
+		b.ptrs[i] = nil
+		b.caps[i] = 0
+	}
+}
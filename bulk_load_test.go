@@ -0,0 +1,148 @@
+package sophia
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseBulkLoad(t *testing.T) {
+	const (
+		keyPath      = "key"
+		valuePath    = "value"
+		countPath    = "count"
+		recordsCount = 1000
+		chunkSize    = 100
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(keyPath, FieldTypeUInt64))
+	require.Nil(t, schema.AddValue(valuePath, FieldTypeString))
+	require.Nil(t, schema.AddValue(countPath, FieldTypeUInt32))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+	env.SetBatchOptions(BatchOptions{MaxDocuments: chunkSize})
+
+	values := make([][]byte, recordsCount)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("value%v", i))
+	}
+	reader := func(from, to uint64) RowReaderFunc {
+		key := from
+		return func(row Row) error {
+			if key >= to {
+				return io.EOF
+			}
+			if err := row.SetUint64(keyPath, key); err != nil {
+				return err
+			}
+			if err := row.SetBytes(valuePath, values[key%recordsCount]); err != nil {
+				return err
+			}
+			if err := row.SetUint32(countPath, uint32(key)); err != nil {
+				return err
+			}
+			key++
+			return nil
+		}
+	}
+
+	stats, err := db.BulkLoad(reader(0, recordsCount))
+	require.Nil(t, err)
+	require.Equal(t, recordsCount, stats.Rows)
+	require.Equal(t, recordsCount/chunkSize, stats.Chunks)
+	require.True(t, stats.Bytes > recordsCount*12)
+	require.True(t, stats.RowsPerSecond() > 0)
+
+	cursor, err := db.Cursor(db.Document())
+	require.Nil(t, err)
+	var key uint64
+	for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+		k, err := d.GetUint64(keyPath)
+		require.Nil(t, err)
+		require.Equal(t, key, k)
+		value, err := d.GetBytes(valuePath)
+		require.Nil(t, err)
+		require.Equal(t, values[key], value)
+		count, err := d.GetUint32(countPath)
+		require.Nil(t, err)
+		require.Equal(t, uint32(key), count)
+		key++
+	}
+	require.Nil(t, cursor.Err())
+	require.Nil(t, cursor.Close())
+	require.Equal(t, uint64(recordsCount), key)
+
+	t.Run("Order", func(t *testing.T) {
+		next := reader(recordsCount, 2*recordsCount)
+		n := 0
+		stats, err := db.BulkLoad(RowReaderFunc(func(row Row) error {
+			n++
+			if n == 250 {
+				return row.SetUint64(keyPath, 0)
+			}
+			return next(row)
+		}))
+		require.True(t, errors.Is(err, ErrBulkLoadOrder))
+		require.Equal(t, 2*chunkSize, stats.Rows)
+		require.Equal(t, 2, stats.Chunks)
+	})
+
+	t.Run("ReaderError", func(t *testing.T) {
+		expectedErr := errors.New("expected error")
+		stats, err := db.BulkLoad(RowReaderFunc(func(row Row) error {
+			return expectedErr
+		}))
+		require.Equal(t, expectedErr, err)
+		require.Equal(t, 0, stats.Rows)
+	})
+
+	t.Run("MissingKey", func(t *testing.T) {
+		_, err := db.BulkLoad(RowReaderFunc(func(row Row) error {
+			return row.SetUint32(countPath, 1)
+		}))
+		require.NotNil(t, err)
+	})
+
+	t.Run("Allocations", func(t *testing.T) {
+		from := uint64(10 * recordsCount)
+		allocs := testing.AllocsPerRun(1, func() {
+			_, err := db.BulkLoad(reader(from, from+recordsCount))
+			require.Nil(t, err)
+			from += recordsCount
+		})
+		require.True(t, allocs < recordsCount/10, "%v allocations per %v rows", allocs, recordsCount)
+	})
+}
+
+func TestRowBuffersAllocationError(t *testing.T) {
+	buffers := newRowBuffers(1)
+	defer buffers.free()
+	_, err := buffers.get(0, math.MaxInt/2)
+	require.Error(t, err)
+	b, err := buffers.get(0, 10)
+	require.Nil(t, err)
+	require.Len(t, b, 10)
+}
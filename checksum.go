@@ -0,0 +1,144 @@
+package sophia
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// Sizes of packed structures of sophia node file
+const (
+	nodeIndexHeaderSize = 89
+	nodeIndexPageSize   = 40
+	nodePageHeaderSize  = 56
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// sophiaCRC calculates crc32c the way sophia does: without inversion of crc
+func sophiaCRC(crc uint32, p []byte) uint32 {
+	return ^crc32.Update(^crc, crcTable, p)
+}
+
+// nodeIndexHeader is a part of sdindexheader which is needed for node file traversal
+type nodeIndexHeader struct {
+	crc    uint32
+	offset uint64
+	size   uint32
+	count  uint32
+	total  uint64
+	align  uint16
+}
+
+func parseNodeIndexHeader(b []byte) nodeIndexHeader {
+	return nodeIndexHeader{
+		crc:    binary.LittleEndian.Uint32(b[0:]),
+		offset: binary.LittleEndian.Uint64(b[15:]),
+		size:   binary.LittleEndian.Uint32(b[23:]),
+		count:  binary.LittleEndian.Uint32(b[31:]),
+		total:  binary.LittleEndian.Uint64(b[39:]),
+		align:  binary.LittleEndian.Uint16(b[87:]),
+	}
+}
+
+// verifyNodeFile checks crc of every node index and page of sophia node file.
+// Checksum of page data is verified only for uncompressed pages
+// which have been written with page checksum enabled, only headers of compressed pages are read.
+// File is read page by page, offsets and sizes are checked before reading,
+// so a corrupted file is reported by error.
+// Returns number of verified pages.
+func verifyNodeFile(path string, compressed bool) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	corrupted := func(reason string) error {
+		return fmt.Errorf("corrupted db file '%v': %v", path, reason)
+	}
+	if info.Size() < nodeIndexHeaderSize {
+		return 0, corrupted("bad size")
+	}
+	var pages int
+	var buf []byte
+	raw := make([]byte, nodeIndexHeaderSize)
+	end := uint64(info.Size())
+	for end > 0 {
+		if end < nodeIndexHeaderSize {
+			return pages, corrupted("bad index header")
+		}
+		headerOffset := end - nodeIndexHeaderSize
+		if _, err = f.ReadAt(raw, int64(headerOffset)); err != nil {
+			return pages, err
+		}
+		header := parseNodeIndexHeader(raw)
+		if header.crc != sophiaCRC(0, raw[4:]) {
+			return pages, corrupted("bad index crc")
+		}
+		indexSize := uint64(header.align) + uint64(header.size)
+		if header.offset > headerOffset || headerOffset-header.offset != indexSize ||
+			header.total > header.offset || uint64(header.count)*nodeIndexPageSize > uint64(header.size) {
+			return pages, corrupted("bad index header")
+		}
+		entries := make([]byte, uint64(header.count)*nodeIndexPageSize)
+		if _, err = f.ReadAt(entries, int64(headerOffset-uint64(header.align)-uint64(len(entries)))); err != nil {
+			return pages, err
+		}
+		for i := 0; i < len(entries); i += nodeIndexPageSize {
+			offset := binary.LittleEndian.Uint64(entries[i:])
+			size := uint64(binary.LittleEndian.Uint32(entries[i+12:]))
+			if offset > header.offset || size > header.offset-offset || size < nodePageHeaderSize {
+				return pages, corrupted("bad page offset")
+			}
+			if compressed {
+				size = nodePageHeaderSize
+			}
+			if uint64(cap(buf)) < size {
+				buf = make([]byte, size)
+			}
+			page := buf[:size]
+			if _, err = f.ReadAt(page, int64(offset)); err != nil {
+				return pages, err
+			}
+			if err = verifyPage(page, compressed); err != nil {
+				return pages, corrupted(fmt.Sprintf("page at offset %v: %v", offset, err))
+			}
+			pages++
+		}
+		end = header.offset - header.total
+	}
+	return pages, nil
+}
+
+// verifyPage checks crc of page header and crc of page data
+func verifyPage(page []byte, compressed bool) error {
+	header := page[:nodePageHeaderSize]
+	if binary.LittleEndian.Uint32(header[0:]) != sophiaCRC(0, header[4:]) {
+		return fmt.Errorf("bad page header crc")
+	}
+	crcData := binary.LittleEndian.Uint32(header[4:])
+	if compressed || crcData == 0 {
+		return nil
+	}
+	sizeOrigin := uint64(binary.LittleEndian.Uint32(header[16:]))
+	if nodePageHeaderSize+sizeOrigin > uint64(len(page)) {
+		return fmt.Errorf("bad page size")
+	}
+	// data crc is calculated before crc and sizes are set
+	origin := make([]byte, nodePageHeaderSize)
+	copy(origin, header)
+	for _, offset := range []int{0, 4, 16, 20} {
+		binary.LittleEndian.PutUint32(origin[offset:], 0)
+	}
+	crc := sophiaCRC(0, origin)
+	crc = sophiaCRC(crc, page[nodePageHeaderSize:nodePageHeaderSize+sizeOrigin])
+	if crc != crcData {
+		return fmt.Errorf("bad page data crc")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package sophia
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+)
+
+/*
+extern int goCompareCall(char *a, int a_size, char *b, int b_size, void *arg);
+*/
+import "C"
+
+const (
+	keyComparatorTemplate    = "db.%v.comparator"
+	keyComparatorArgTemplate = "db.%v.comparator_arg"
+	keyDatabasePath          = "db.%v.path"
+)
+
+// comparatorFile is a file in the database directory, which keeps ComparatorName
+const comparatorFile = "comparator"
+
+// Comparator compares key fields a and b.
+// It should return a negative number if a < b, zero if a == b and a positive number if a > b.
+// Slices are valid only during the call.
+type Comparator func(a, b []byte) int
+
+// comparatorBinding binds Comparator to the database.
+// It is passed to sophia as comparator_arg and lives until the environment is closed.
+type comparatorBinding struct {
+	db  *Database
+	cmp Comparator
+}
+
+//export goCompareCall
+func goCompareCall(a *C.char, aSize C.int, b *C.char, bSize C.int, arg unsafe.Pointer) (rc C.int) {
+	cb := cgo.Handle(uintptr(arg)).Value().(*comparatorBinding)
+	x := unsafe.Slice((*byte)(unsafe.Pointer(a)), int(aSize))
+	y := unsafe.Slice((*byte)(unsafe.Pointer(b)), int(bSize))
+	// panic can't be propagated through C code, keys are compared bytewise instead
+	defer func() {
+		if r := recover(); r != nil {
+			cb.db.reportCallbackError(fmt.Errorf("comparator panic: %v", r))
+			rc = C.int(bytes.Compare(x, y))
+		}
+	}()
+	res := cb.cmp(x, y)
+	// sophia expects exactly -1, 0 or 1
+	switch {
+	case res < 0:
+		return -1
+	case res > 0:
+		return 1
+	}
+	return 0
+}
+
+func (env *Environment) setComparator(name string, cb *comparatorBinding) error {
+	arg := handlePointer(env.newHandle(cb))
+	if !env.Set(fmt.Sprintf(keyComparatorTemplate, name), C.goCompareCall) ||
+		!env.Set(fmt.Sprintf(keyComparatorArgTemplate, name), arg) {
+		return fmt.Errorf("failed to set comparator: %v", env.Error())
+	}
+	return nil
+}
+
+// databasePath returns directory of the database files
+func (env *Environment) databasePath(name string) string {
+	if path := env.configString(fmt.Sprintf(keyDatabasePath, name)); path != "" {
+		return path
+	}
+	return filepath.Join(env.configString(EnvironmentPath), name)
+}
+
+// validateComparator checks that ComparatorName of the database is the same as persisted one.
+// Name is persisted when the database is created, existed is set if database files existed before Open.
+// Sophia doesn't keep comparator, so a database opened with another one would be read in wrong order.
+func (env *Environment) validateComparator(db *Database, existed bool) error {
+	file := filepath.Join(env.databasePath(db.name), comparatorFile)
+	if !existed {
+		if db.comparatorName == "" {
+			return nil
+		}
+		if err := ioutil.WriteFile(file, []byte(db.comparatorName), 0644); err != nil {
+			return fmt.Errorf("failed to save database '%v' comparator: %v", db.name, err)
+		}
+		return nil
+	}
+	var actual string
+	data, err := ioutil.ReadFile(file)
+	switch {
+	case err == nil:
+		actual = strings.TrimSpace(string(data))
+	case !os.IsNotExist(err):
+		return fmt.Errorf("failed to read database '%v' comparator: %v", db.name, err)
+	}
+	if actual != db.comparatorName {
+		return fmt.Errorf("database '%v' comparator mismatch: expected '%v', found '%v'",
+			db.name, db.comparatorName, actual)
+	}
+	return nil
+}
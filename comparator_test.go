@@ -0,0 +1,156 @@
+package sophia
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseComparator(t *testing.T) {
+	const dbName = "test_database"
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	caseInsensitive := func(a, b []byte) int {
+		return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+	}
+	open := func() (*Environment, *Database) {
+		env, err := NewEnvironment()
+		require.Nil(t, err)
+		require.True(t, env.SetString(EnvironmentPath, tmpDir))
+		db, err := env.NewDatabase(DatabaseConfig{
+			Name:           dbName,
+			Comparator:     caseInsensitive,
+			ComparatorName: "case_insensitive",
+		})
+		require.Nil(t, err)
+		require.Nil(t, env.Open())
+		return env, db
+	}
+	keys := func(db *Database, order Order) []string {
+		doc := db.Document()
+		require.True(t, doc.Set(CursorOrder, order))
+		cursor, err := db.Cursor(doc)
+		require.Nil(t, err)
+		defer cursor.Close()
+		var res []string
+		for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+			key, err := d.GetBytes("key")
+			require.Nil(t, err)
+			res = append(res, string(key))
+		}
+		return res
+	}
+
+	env, db := open()
+	for _, key := range []string{"cherry", "Banana", "apple", "BANANA"} {
+		doc := db.Document()
+		doc.Set("key", key)
+		doc.Set("value", key)
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+	}
+	require.Equal(t, []string{"apple", "BANANA", "cherry"}, keys(db, GreaterThanEqual))
+	require.Equal(t, []string{"cherry", "BANANA", "apple"}, keys(db, LessThanEqual))
+
+	doc := db.Document()
+	doc.Set("key", "APPLE")
+	d, err := db.Get(doc)
+	doc.Free()
+	require.Nil(t, err)
+	value, err := d.GetBytes("value")
+	require.Nil(t, err)
+	require.Equal(t, "apple", string(value))
+	require.Nil(t, d.Destroy())
+
+	require.Nil(t, env.Checkpoint())
+	require.Nil(t, env.Close())
+
+	env, db = open()
+	defer env.Close()
+	require.Equal(t, []string{"apple", "BANANA", "cherry"}, keys(db, GreaterThanEqual))
+}
+
+func TestDatabaseComparatorPanic(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	defer env.Close()
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+	var (
+		mu       sync.Mutex
+		reported []error
+	)
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+		Comparator: func(a, b []byte) int {
+			panic("comparator failure")
+		},
+		ComparatorName: "panic",
+		OnCallbackError: func(err error) {
+			mu.Lock()
+			reported = append(reported, err)
+			mu.Unlock()
+		},
+	})
+	require.Nil(t, err)
+	require.Nil(t, env.Open())
+
+	for _, key := range []string{"b", "a"} {
+		doc := db.Document()
+		doc.Set("key", key)
+		doc.Set("value", key)
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+	}
+	doc := db.Document()
+	doc.Set("key", "a")
+	d, err := db.Get(doc)
+	doc.Free()
+	require.Nil(t, err)
+	require.Nil(t, d.Destroy())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, reported)
+	require.EqualError(t, reported[0], "comparator panic: comparator failure")
+}
+
+func TestDatabaseComparatorName(t *testing.T) {
+	const dbName = "test_database"
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	open := func(config DatabaseConfig) error {
+		env, err := NewEnvironment()
+		require.Nil(t, err)
+		defer env.Close()
+		require.True(t, env.SetString(EnvironmentPath, tmpDir))
+		config.Name = dbName
+		if _, err = env.NewDatabase(config); err != nil {
+			return err
+		}
+		return env.Open()
+	}
+
+	require.EqualError(t, open(DatabaseConfig{Comparator: bytes.Compare}),
+		"illegal configuration: Comparator and ComparatorName must be set together")
+	require.EqualError(t, open(DatabaseConfig{ComparatorName: "bytes"}),
+		"illegal configuration: Comparator and ComparatorName must be set together")
+
+	require.Nil(t, open(DatabaseConfig{Comparator: bytes.Compare, ComparatorName: "bytes"}))
+	require.Nil(t, open(DatabaseConfig{Comparator: bytes.Compare, ComparatorName: "bytes"}))
+	require.EqualError(t, open(DatabaseConfig{Comparator: bytes.Compare, ComparatorName: "other"}),
+		"database 'test_database' comparator mismatch: expected 'other', found 'bytes'")
+	require.EqualError(t, open(DatabaseConfig{}),
+		"database 'test_database' comparator mismatch: expected '', found 'bytes'")
+}
@@ -1,7 +1,9 @@
 package sophia
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"unsafe"
 )
 
@@ -28,25 +30,69 @@ const (
 	CursorOrder = "order"
 )
 
+// keyCursorError is set by sophia if the last read of cursor has failed, it distinguishes errors from the end of data
+const keyCursorError = "error"
+
 // ErrCursorClosed will be returned in case of closed cursor usage
 var ErrCursorClosed = errors.New("usage of closed Cursor")
 
 // Cursor iterates over key-values in a database.
 type Cursor struct {
-	ptr    unsafe.Pointer
-	doc    Document
+	ptr unsafe.Pointer
+	env *Environment
+	// tx is a transaction or Snapshot the cursor belongs to, it is nil for database cursors
+	tx *Transaction
+	// doc is a key of the first read, sophia consumes it
+	doc Document
+	// last is a document returned by Next, it's destroyed by the next Next or Close
+	last   Document
 	closed bool
+	// finished is set when sophia has returned no document
+	finished bool
+	err      error
+	// live tracks lifetime of the cursor in debug mode
+	live *liveObject
+}
+
+// newCursor creates a Cursor from environment or transaction object
+func newCursor(ptr unsafe.Pointer, env *Environment, doc Document) (*Cursor, error) {
+	if doc.IsEmpty() {
+		return nil, errors.New("failed to create cursor: nil Document")
+	}
+	cPtr := spCursor(ptr)
+	if nil == cPtr {
+		return nil, fmt.Errorf("failed to create cursor: err=%v", env.Error())
+	}
+	doc.live.release("it has been passed to Cursor")
+	doc.live = nil
+	return &Cursor{
+		ptr:  cPtr,
+		env:  env,
+		doc:  doc,
+		live: env.tracker.track("Cursor"),
+	}, nil
 }
 
 // Close closes the cursor. If a cursor is not closed, future operations
 // on the database can hang indefinitely.
-// Cursor won't be accessible after this
+// Cursor won't be accessible after this, as well as the last Document returned by Next.
 func (cur *Cursor) Close() error {
 	if cur.closed {
 		return ErrCursorClosed
 	}
+	// key hasn't been passed to sophia by Next
+	if cur.doc.ptr != nil {
+		cur.doc.live.release("Cursor Close")
+		spDestroy(cur.doc.ptr)
+		cur.doc.ptr = nil
+	}
 	cur.doc.Free()
+	cur.releaseLast("Cursor Close")
+	cur.live.release("Close")
 	cur.closed = true
+	if cur.tx != nil {
+		cur.tx.removeCursor(cur)
+	}
 	if !spDestroy(cur.ptr) {
 		return errors.New("cursor: failed to close")
 	}
@@ -54,15 +100,68 @@ func (cur *Cursor) Close() error {
 }
 
 // Next fetches the next row for the cursor
-// Returns next row if it exists else it will return nil
+// Returns next row if it exists else it will return empty Document,
+// Err should be checked then to distinguish an error from the end of data.
+// Every call returns a new Document, which is owned by the cursor:
+// it must not be destroyed and it is valid only until the next Next or Close call.
 func (cur *Cursor) Next() Document {
-	if cur.closed {
+	if cur.done() {
 		return Document{}
 	}
-	ptr := spGet(cur.ptr, cur.doc.ptr)
+	var ptr unsafe.Pointer
+	if cur.doc.ptr != nil {
+		cur.doc.live.release("it has been passed to Cursor.Next")
+		ptr = spGet(cur.ptr, cur.doc.ptr)
+		cur.doc.ptr = nil
+		cur.doc.unpin()
+	} else {
+		// sophia continues from the previous document and doesn't consume it
+		ptr = spGet(cur.ptr, cur.last.ptr)
+		cur.releaseLast("the next Cursor.Next call")
+	}
 	if ptr == nil {
+		cur.finished = true
+		if spGetInt(cur.ptr, getCStringFromCache(keyCursorError)) != 0 {
+			cur.err = fmt.Errorf("cursor: failed to get next document: %v", cur.env.Error())
+		}
 		return Document{}
 	}
-	cur.doc.ptr = ptr
-	return cur.doc
+	cur.last = Document{
+		varStore: newVarStore(ptr, 0),
+		db:       cur.doc.db,
+	}
+	cur.last.live = cur.env.tracker.object("Document returned by Cursor")
+	return cur.last
+}
+
+// done returns true if the cursor can't return documents anymore
+func (cur *Cursor) done() bool {
+	return cur.closed || cur.err != nil || cur.finished
+}
+
+// releaseLast destroys the document returned by the last Next call
+func (cur *Cursor) releaseLast(reason string) {
+	if cur.last.ptr == nil {
+		return
+	}
+	cur.last.live.release(reason)
+	spDestroy(cur.last.ptr)
+	cur.last = Document{}
+}
+
+// NextContext is like Next, but it stops iteration if ctx is done, Err returns ctx error then.
+func (cur *Cursor) NextContext(ctx context.Context) Document {
+	if cur.done() {
+		return Document{}
+	}
+	if err := ctx.Err(); err != nil {
+		cur.err = fmt.Errorf("cursor: %w", err)
+		return Document{}
+	}
+	return cur.Next()
+}
+
+// Err returns the error, which has stopped iteration, if any
+func (cur *Cursor) Err() error {
+	return cur.err
 }
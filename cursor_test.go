@@ -55,6 +55,40 @@ func TestCursor(t *testing.T) {
 	t.Run("Quarter records", func(t *testing.T) { testCursor(t, db, recordsCount/4, recordsCount, valueTemplate) })
 	t.Run("Use closed cursor error", func(t *testing.T) { testCursorError(t, db) })
 	t.Run("Reverse iterator", func(t *testing.T) { testReverseCursor(t, db, recordsCount, valueTemplate) })
+	t.Run("Independent documents", func(t *testing.T) { testCursorDocuments(t, db, recordsCount) })
+	t.Run("Errors of other calls", func(t *testing.T) { testCursorOtherErrors(t, env, db, recordsCount) })
+}
+
+func testCursorDocuments(t *testing.T, db *Database, count int64) {
+	cursor, err := db.Cursor(db.Document())
+	require.Nil(t, err)
+	defer cursor.Close()
+
+	var prev Document
+	var id int64
+	for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+		require.NotEqual(t, prev.ptr, d.ptr)
+		require.Equal(t, id, d.GetInt("key"))
+		prev = d
+		id++
+	}
+	require.Nil(t, cursor.Err())
+	require.Equal(t, count, id)
+}
+
+func testCursorOtherErrors(t *testing.T, env *Environment, db *Database, count int64) {
+	cursor, err := db.Cursor(db.Document())
+	require.Nil(t, err)
+	defer cursor.Close()
+
+	var id int64
+	for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+		// errors of other calls don't stop iteration
+		require.False(t, env.SetString("unknown.option", "value"))
+		id++
+	}
+	require.Nil(t, cursor.Err())
+	require.Equal(t, count, id)
 }
 
 func testCursorError(t *testing.T, db *Database) {
@@ -98,6 +132,7 @@ func testCursor(t *testing.T, db *Database, start, count int64, valueTemplate st
 		counter++
 		id++
 	}
+	require.Nil(t, cursor.Err())
 	require.Equal(t, count-start, counter)
 }
 
@@ -1,6 +1,7 @@
 package sophia
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"unsafe"
@@ -13,11 +14,16 @@ var ErrNotFound = errors.New("document not found")
 type dataStore struct {
 	ptr unsafe.Pointer
 	env *Environment
+	// live tracks lifetime of transaction in debug mode
+	live *liveObject
 }
 
 // Get retrieves the row for the set of keys.
 func (d *dataStore) Get(doc Document) (Document, error) {
+	d.live.check()
+	doc.live.release("it has been passed to Get")
 	ptr := spGet(d.ptr, doc.ptr)
+	doc.unpin()
 	if ptr == nil {
 		err := d.env.Error()
 		if err == nil {
@@ -25,20 +31,53 @@ func (d *dataStore) Get(doc Document) (Document, error) {
 		}
 		return Document{}, fmt.Errorf("failed Get document: err=%v", err)
 	}
-	return newDocument(ptr, 0), nil
+	ret := newDocument(ptr, 0)
+	ret.db = doc.db
+	ret.live = d.env.tracker.track("Document")
+	return ret, nil
+}
+
+// GetContext is like Get, but if ctx is done, it's error is returned without reading.
+// doc is released in any case, as it is by Get.
+func (d *dataStore) GetContext(ctx context.Context, doc Document) (Document, error) {
+	if err := ctx.Err(); err != nil {
+		d.live.check()
+		doc.discard("it has been passed to GetContext")
+		return Document{}, err
+	}
+	return d.Get(doc)
 }
 
 // Set sets the row of the set of keys.
 func (d *dataStore) Set(doc Document) error {
-	if !spSet(d.ptr, doc.ptr) {
+	d.live.check()
+	doc.live.release("it has been passed to Set")
+	ok := spSet(d.ptr, doc.ptr)
+	doc.unpin()
+	if !ok {
 		return fmt.Errorf("failed Set document: err=%v", d.env.Error())
 	}
 	return nil
 }
 
+// SetContext is like Set, but if ctx is done, it's error is returned without writing.
+// doc is released in any case, as it is by Set.
+func (d *dataStore) SetContext(ctx context.Context, doc Document) error {
+	if err := ctx.Err(); err != nil {
+		d.live.check()
+		doc.discard("it has been passed to SetContext")
+		return err
+	}
+	return d.Set(doc)
+}
+
 // Upsert sets the row of the set of keys.
 func (d *dataStore) Upsert(doc Document) error {
-	if !spUpsert(d.ptr, doc.ptr) {
+	d.live.check()
+	doc.live.release("it has been passed to Upsert")
+	ok := spUpsert(d.ptr, doc.ptr)
+	doc.unpin()
+	if !ok {
 		return fmt.Errorf("failed Upsert document: err=%v", d.env.Error())
 	}
 	return nil
@@ -46,7 +85,11 @@ func (d *dataStore) Upsert(doc Document) error {
 
 // Delete deletes row with specified set of keys.
 func (d *dataStore) Delete(doc Document) error {
-	if !spDelete(d.ptr, doc.ptr) {
+	d.live.check()
+	doc.live.release("it has been passed to Delete")
+	ok := spDelete(d.ptr, doc.ptr)
+	doc.unpin()
+	if !ok {
 		return fmt.Errorf("failed Delete document: err=%v", d.env.Error())
 	}
 	return nil
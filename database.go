@@ -1,8 +1,7 @@
 package sophia
 
 import (
-	"errors"
-	"fmt"
+	"log/slog"
 )
 
 const (
@@ -16,6 +15,7 @@ const (
 	keyMmap                   = "db.%v.mmap"
 	keyCompression            = "db.%v.compression"
 	keyDirectIO               = "db.%v.direct_io"
+	keyExpire                 = "db.%v.expire"
 	keySync                   = "db.%v.sync"
 )
 
@@ -65,14 +65,39 @@ type DatabaseConfig struct {
 	// DisableSync can be set to disable sync node file on compaction completion.
 	DisableSync bool
 	// Expire can be set to enable or disable key expire.
+	// If it is enabled, u32 value field TimestampField is added to schema.
+	// Sophia sets it to the document creation time, if it wasn't set explicitly.
+	// Expired documents are removed during compaction,
+	// which is checked every CompactionExpirePeriod seconds.
 	Expire bool
+	// ExpireTTL time to live of documents in seconds, it is required if Expire is enabled.
+	// TTL of a single document can be decreased with Document.SetTTL or Database.SetWithTTL.
+	ExpireTTL int64
 	// Compression specify compression driver. Supported: lz4, zstd, none (default).
 	Compression CompressionType
+	// Comparator is used instead of default comparison of key fields.
+	// It is called for every key field separately, fixed size fields are passed in their little-endian representation.
+	// Prefix search of cursors still compares bytes.
+	// If it panics, the panic is reported to OnCallbackError and keys are compared bytewise for that call,
+	// which breaks order of the database, so Comparator must not panic.
+	Comparator Comparator
+	// ComparatorName identifies Comparator, it is required if Comparator is set.
+	// It is saved when the database is created, Open fails if the database is opened with another one.
+	ComparatorName string
 	// Upsert is a function that will be called on every upsert operation.
 	// If it was not set during the configuration database, upsert operation will not be available
 	Upsert UpsertFunc
 	// UpsertArg an argument which is additionally passed every call
 	UpsertArg interface{}
+	// UpsertHandler is a safe alternative to Upsert, only one of them can be set.
+	UpsertHandler UpsertHandler
+	// UpsertOps describes upsert with built-in operations, it can't be used with Upsert and UpsertHandler.
+	UpsertOps UpsertOps
+	// OnCallbackError is called with errors of Go callbacks, which are called by sophia:
+	// panics of Comparator and upsert callbacks, failures of UpsertFunc and errors of UpsertHandler.
+	// It is called from sophia threads concurrently and must not use the database.
+	// If it isn't set, errors are logged to EnvironmentConfig.Logger or to the default logger.
+	OnCallbackError func(err error)
 }
 
 // Database is used for accessing a database.
@@ -83,6 +108,21 @@ type Database struct {
 	name        string
 	schema      *Schema
 	fieldsCount int
+	// positions of schema fields in sophia scheme
+	positions map[string]int
+	// expireTTL time to live of documents in seconds, 0 if expire is disabled
+	expireTTL int64
+	// comparator of key fields, nil for default comparison
+	comparator     Comparator
+	comparatorName string
+	// onCallbackError receives errors of Go callbacks
+	onCallbackError func(err error)
+	upsertErrors    upsertErrors
+}
+
+// Name returns name of the database
+func (db *Database) Name() string {
+	return db.name
 }
 
 // Document creates a Document for a single or multi-statement transactions
@@ -91,20 +131,22 @@ func (db *Database) Document() Document {
 	if ptr == nil {
 		return Document{}
 	}
-	return newDocument(ptr, db.fieldsCount)
+	doc := newDocument(ptr, db.fieldsCount)
+	doc.db = db
+	doc.live = db.env.tracker.track("Document")
+	return doc
+}
+
+// reportCallbackError passes error of a Go callback called by sophia to OnCallbackError or logs it
+func (db *Database) reportCallbackError(err error) {
+	if db.onCallbackError != nil {
+		db.onCallbackError(err)
+		return
+	}
+	db.env.logger().Error("sophia: callback error", slog.String("database", db.name), slog.Any("error", err))
 }
 
 // Cursor returns a Cursor for iterating over rows in the database
 func (db *Database) Cursor(doc Document) (*Cursor, error) {
-	if doc.IsEmpty() {
-		return nil, errors.New("failed to create cursor: nil Document")
-	}
-	cPtr := spCursor(db.env.ptr)
-	if nil == cPtr {
-		return nil, fmt.Errorf("failed to create cursor: err=%v", db.env.Error())
-	}
-	return &Cursor{
-		ptr: cPtr,
-		doc: doc,
-	}, nil
+	return newCursor(db.env.ptr, db.env, doc)
 }
@@ -1,6 +1,7 @@
 package sophia
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
@@ -191,6 +192,70 @@ func TestDatabaseGet(t *testing.T) {
 	d.Destroy()
 }
 
+func TestDatabaseContext(t *testing.T) {
+	const (
+		keyPath       = "key"
+		valuePath     = "value"
+		expectedKey   = "key1"
+		expectedValue = "value1"
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	doc := db.Document()
+	require.True(t, doc.SetString(keyPath, expectedKey))
+	require.True(t, doc.SetString(valuePath, expectedValue))
+	require.Nil(t, db.SetContext(ctx, doc))
+	doc.Free()
+
+	doc = db.Document()
+	require.True(t, doc.SetString(keyPath, expectedKey))
+	d, err := db.GetContext(ctx, doc)
+	doc.Free()
+	require.Nil(t, err)
+	var size int
+	require.Equal(t, expectedValue, d.GetString(valuePath, &size))
+	d.Destroy()
+
+	cancel()
+
+	doc = db.Document()
+	require.True(t, doc.SetString(keyPath, "key2"))
+	require.Equal(t, context.Canceled, db.SetContext(ctx, doc))
+	doc.Free()
+
+	doc = db.Document()
+	require.True(t, doc.SetString(keyPath, expectedKey))
+	d, err = db.GetContext(ctx, doc)
+	doc.Free()
+	require.Equal(t, context.Canceled, err)
+	require.True(t, d.IsEmpty())
+
+	doc = db.Document()
+	require.True(t, doc.SetString(keyPath, "key2"))
+	_, err = db.Get(doc)
+	doc.Free()
+	require.Equal(t, ErrNotFound, err)
+}
+
 func TestDatabaseDeleteFromClosedEnvironment(t *testing.T) {
 	const keyPath = "key"
 	const expectedKey = "key1"
@@ -0,0 +1,165 @@
+package sophia
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrLeaked is returned by Close of environment in debug mode,
+// if there are Documents, Cursors or Transactions, which haven't been released.
+var ErrLeaked = errors.New("sophia objects leaked")
+
+// tracker keeps live objects of environment in debug mode
+type tracker struct {
+	mu     sync.Mutex
+	seq    uint64
+	live   map[uint64]*objectInfo
+	logger *slog.Logger
+}
+
+// objectInfo describes where tracked object has been created
+type objectInfo struct {
+	kind    string
+	callers []uintptr
+}
+
+// liveObject tracks lifetime of a C object in debug mode.
+// Copies of Go values, which refer to the same C object, share it.
+// Nil liveObject is used when debug mode is disabled, all it's methods do nothing then.
+type liveObject struct {
+	info    *objectInfo
+	tracker *tracker
+	// id of the object in tracker, zero for objects which aren't checked for leaks
+	id       uint64
+	released atomic.Bool
+	reason   string
+}
+
+func newTracker(logger *slog.Logger) *tracker {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &tracker{
+		live:   make(map[uint64]*objectInfo),
+		logger: logger,
+	}
+}
+
+// track creates liveObject for a C object, which is released by user.
+// If it is garbage collected without being released, the leak is logged.
+func (t *tracker) track(kind string) *liveObject {
+	if t == nil {
+		return nil
+	}
+	o := t.object(kind)
+	t.mu.Lock()
+	t.seq++
+	o.id = t.seq
+	t.live[o.id] = o.info
+	t.mu.Unlock()
+	runtime.SetFinalizer(o, (*liveObject).finalize)
+	return o
+}
+
+// object creates liveObject for a C object, which is released by sophia or by another object
+func (t *tracker) object(kind string) *liveObject {
+	if t == nil {
+		return nil
+	}
+	callers := make([]uintptr, 32)
+	return &liveObject{
+		info: &objectInfo{
+			kind:    kind,
+			callers: callers[:runtime.Callers(3, callers)],
+		},
+		tracker: t,
+	}
+}
+
+// leaks returns error describing objects, which haven't been released
+func (t *tracker) leaks() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	ids := make([]uint64, 0, len(t.live))
+	for id := range t.live {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	leaks := make([]string, 0, len(ids))
+	for _, id := range ids {
+		info := t.live[id]
+		leaks = append(leaks, fmt.Sprintf("%v created at %v", info.kind, info.location()))
+	}
+	t.mu.Unlock()
+	if len(leaks) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrLeaked, strings.Join(leaks, "; "))
+}
+
+// check panics if the object has been released
+func (o *liveObject) check() {
+	if o == nil || !o.released.Load() {
+		return
+	}
+	panic(fmt.Sprintf("sophia: usage of %v after %v, it was created at:\n%v",
+		o.info.kind, o.reason, o.info.stack()))
+}
+
+// release marks the object as released for given reason, it panics if the object has been already released
+func (o *liveObject) release(reason string) {
+	if o == nil {
+		return
+	}
+	o.check()
+	o.reason = reason
+	o.released.Store(true)
+	if o.id != 0 {
+		o.tracker.mu.Lock()
+		delete(o.tracker.live, o.id)
+		o.tracker.mu.Unlock()
+	}
+}
+
+func (o *liveObject) finalize() {
+	if o.released.Load() {
+		return
+	}
+	o.tracker.logger.Error(fmt.Sprintf("sophia: %v is garbage collected without being released", o.info.kind),
+		slog.String("created", o.info.stack()))
+}
+
+// location returns the first frame of creation stack outside of the package
+func (info *objectInfo) location() string {
+	frames := runtime.CallersFrames(info.callers)
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePrefix) || strings.HasSuffix(frame.File, "_test.go") || !more {
+			return fmt.Sprintf("%v:%v", frame.File, frame.Line)
+		}
+	}
+}
+
+func (info *objectInfo) stack() string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(info.callers)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%v\n\t%v:%v\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+// packagePrefix is a prefix of functions names of the package
+var packagePrefix = reflect.TypeOf(tracker{}).PkgPath() + "."
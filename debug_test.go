@@ -0,0 +1,142 @@
+package sophia
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a buffer, which can be written by finalizers concurrently with reading
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestEnvironmentDebug(t *testing.T) {
+	const (
+		keyPath   = "key"
+		valuePath = "value"
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	var buf syncBuffer
+	env, err := NewEnvironmentWithConfig(EnvironmentConfig{
+		Path:   tmpDir,
+		Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+		Debug:  true,
+	})
+	require.Nil(t, err)
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.Nil(t, env.Open())
+
+	set := func(key, value string) {
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, key))
+		require.True(t, doc.Set(valuePath, value))
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+	}
+	get := func(key string) Document {
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, key))
+		d, err := db.Get(doc)
+		doc.Free()
+		require.Nil(t, err)
+		return d
+	}
+	set("key1", "value1")
+	set("key2", "value2")
+
+	t.Run("UseAfterDestroy", func(t *testing.T) {
+		d := get("key1")
+		b, err := d.GetBytes(valuePath)
+		require.Nil(t, err)
+		require.Equal(t, []byte("value1"), b)
+		require.Nil(t, d.Destroy())
+		require.Panics(t, func() { d.GetBytes(valuePath) })
+		require.Panics(t, func() { d.Destroy() })
+	})
+
+	t.Run("UseAfterWrite", func(t *testing.T) {
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, "key3"))
+		require.Nil(t, db.Set(doc))
+		require.Panics(t, func() { doc.GetInt(valuePath) })
+		require.Panics(t, func() { db.Set(doc) })
+		doc.Free()
+	})
+
+	t.Run("UseAfterNext", func(t *testing.T) {
+		cursor, err := db.Cursor(db.Document())
+		require.Nil(t, err)
+		d := cursor.Next()
+		require.False(t, d.IsEmpty())
+		next := cursor.Next()
+		require.False(t, next.IsEmpty())
+		require.Panics(t, func() { d.GetBytes(keyPath) })
+		d = cursor.Next()
+		require.False(t, d.IsEmpty())
+		require.Nil(t, cursor.Close())
+		require.Panics(t, func() { d.GetBytes(keyPath) })
+	})
+
+	t.Run("UseAfterCommit", func(t *testing.T) {
+		tx, err := env.BeginTx()
+		require.Nil(t, err)
+		require.Equal(t, TxOk, tx.Commit())
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, "key1"))
+		require.Panics(t, func() { tx.Get(doc) })
+		require.Nil(t, doc.Destroy())
+	})
+
+	t.Run("Finalizer", func(t *testing.T) {
+		get("key2")
+		require.Eventually(t, func() bool {
+			runtime.GC()
+			return strings.Contains(buf.String(), "Document is garbage collected without being released")
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	leakedDoc := get("key1")
+	leakedCursor, err := db.Cursor(db.Document())
+	require.Nil(t, err)
+	leakedTx, err := env.BeginTx()
+	require.Nil(t, err)
+
+	err = env.Close()
+	require.True(t, errors.Is(err, ErrLeaked))
+	require.Equal(t, 4, strings.Count(err.Error(), "debug_test.go"), err.Error())
+	require.Contains(t, err.Error(), "Transaction created at")
+	require.Contains(t, err.Error(), "Cursor created at")
+	runtime.KeepAlive(leakedDoc)
+	runtime.KeepAlive(leakedCursor)
+	runtime.KeepAlive(leakedTx)
+}
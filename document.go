@@ -1,14 +1,29 @@
 package sophia
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"unsafe"
 )
 
+var (
+	// ErrUnknownField will be returned in case of usage of field,
+	// that isn't described in database schema
+	ErrUnknownField = errors.New("unknown field")
+	// ErrFieldType will be returned in case of field type mismatch
+	ErrFieldType = errors.New("field type mismatch")
+	// ErrKeyField will be returned in case of change of key field by UpsertHandler
+	ErrKeyField = errors.New("key field can't be changed")
+)
+
 // Document is a representation of a row in a database.
 // Destroy should be called after Document usage.
 type Document struct {
 	varStore
+	// db is a database Document belongs to.
+	// It is used for validation of typed accessors.
+	db *Database
 }
 
 func newDocument(ptr unsafe.Pointer, size int) Document {
@@ -19,8 +34,217 @@ func newDocument(ptr unsafe.Pointer, size int) Document {
 
 // Destroy call C function that releases all resources associated with the Document
 func (d *Document) Destroy() error {
+	d.live.release("Destroy")
 	if !spDestroy(d.ptr) {
 		return errors.New("document: failed to destroy")
 	}
 	return nil
 }
+
+// discard destroys document, which has been passed to a write failed before reaching sophia.
+// Sophia destroys documents passed to writes even if they fail, so callers don't expect it to be alive.
+func (d *Document) discard(reason string) {
+	d.live.release(reason)
+	d.unpin()
+	// documents are destroyed with closed environment
+	if d.db != nil && d.db.env.ptr == nil {
+		return
+	}
+	spDestroy(d.ptr)
+}
+
+// SetUint8 sets value of u8 or u8rev field
+func (d *Document) SetUint8(name string, val uint8) error {
+	return d.setUint(name, uint64(val), FieldTypeUInt8)
+}
+
+// SetUint16 sets value of u16 or u16rev field
+func (d *Document) SetUint16(name string, val uint16) error {
+	return d.setUint(name, uint64(val), FieldTypeUInt16)
+}
+
+// SetUint32 sets value of u32 or u32rev field
+func (d *Document) SetUint32(name string, val uint32) error {
+	return d.setUint(name, uint64(val), FieldTypeUInt32)
+}
+
+// SetUint64 sets value of u64 or u64rev field
+func (d *Document) SetUint64(name string, val uint64) error {
+	return d.setUint(name, val, FieldTypeUInt64)
+}
+
+// SetBytes sets value of string field.
+// Value is copied to C memory, which will be freed on Free() call.
+func (d *Document) SetBytes(name string, val []byte) error {
+	if err := d.checkField(name, FieldTypeString); err != nil {
+		return err
+	}
+	if !d.varStore.SetBytes(name, val) {
+		return fmt.Errorf("failed to set field '%v'", name)
+	}
+	return nil
+}
+
+// SetBytesUnsafe sets value of string field without copying it.
+// Memory of val is pinned until the document is passed to Set, Get, Upsert, Delete or Cursor,
+// which copy values of fields, or until Free() call. val must not be modified till then.
+// Free() must be called, if the document isn't passed to sophia:
+// the Go runtime panics, when it finds pinned memory of a garbage collected document.
+func (d *Document) SetBytesUnsafe(name string, val []byte) error {
+	if err := d.checkField(name, FieldTypeString); err != nil {
+		return err
+	}
+	if !d.varStore.SetBytesPinned(name, val) {
+		return fmt.Errorf("failed to set field '%v'", name)
+	}
+	return nil
+}
+
+// GetUint8 returns value of u8 or u8rev field
+func (d *Document) GetUint8(name string) (uint8, error) {
+	ptr, err := d.getFixed(name, FieldTypeUInt8)
+	if err != nil {
+		return 0, err
+	}
+	return *(*uint8)(ptr), nil
+}
+
+// GetUint16 returns value of u16 or u16rev field
+func (d *Document) GetUint16(name string) (uint16, error) {
+	ptr, err := d.getFixed(name, FieldTypeUInt16)
+	if err != nil {
+		return 0, err
+	}
+	return *(*uint16)(ptr), nil
+}
+
+// GetUint32 returns value of u32 or u32rev field
+func (d *Document) GetUint32(name string) (uint32, error) {
+	ptr, err := d.getFixed(name, FieldTypeUInt32)
+	if err != nil {
+		return 0, err
+	}
+	return *(*uint32)(ptr), nil
+}
+
+// GetUint64 returns value of u64 or u64rev field
+func (d *Document) GetUint64(name string) (uint64, error) {
+	ptr, err := d.getFixed(name, FieldTypeUInt64)
+	if err != nil {
+		return 0, err
+	}
+	return *(*uint64)(ptr), nil
+}
+
+// GetBytes returns copy of string field value.
+// Unlike GetString, result stays valid after Document Destroy() call.
+func (d *Document) GetBytes(name string) ([]byte, error) {
+	if err := d.checkField(name, FieldTypeString); err != nil {
+		return nil, err
+	}
+	var size int
+	ptr := d.Get(name, &size)
+	if ptr == nil {
+		return nil, fmt.Errorf("failed to get field '%v'", name)
+	}
+	return goBytes(ptr, size), nil
+}
+
+// GetBytesUnsafe returns value of string field without copying.
+// Result refers to memory of the Document, it is valid until Destroy() call,
+// for documents returned by Cursor it is valid until the next Next() call.
+func (d *Document) GetBytesUnsafe(name string) ([]byte, error) {
+	if err := d.checkField(name, FieldTypeString); err != nil {
+		return nil, err
+	}
+	var size int
+	ptr := d.Get(name, &size)
+	if ptr == nil {
+		return nil, fmt.Errorf("failed to get field '%v'", name)
+	}
+	return unsafe.Slice((*byte)(ptr), size), nil
+}
+
+func (d *Document) setUint(name string, val uint64, typ FieldType) error {
+	if err := d.checkField(name, typ); err != nil {
+		return err
+	}
+	if !d.SetInt(name, int64(val)) {
+		return fmt.Errorf("failed to set field '%v'", name)
+	}
+	return nil
+}
+
+func (d *Document) getFixed(name string, typ FieldType) (unsafe.Pointer, error) {
+	if err := d.checkField(name, typ); err != nil {
+		return nil, err
+	}
+	var size int
+	ptr := d.Get(name, &size)
+	if ptr == nil {
+		return nil, fmt.Errorf("failed to get field '%v'", name)
+	}
+	if size != typ.size() {
+		return nil, fmt.Errorf("%w: field '%v' has size %v, expected %v", ErrFieldType, name, size, typ.size())
+	}
+	return ptr, nil
+}
+
+// checkField validates that field is described in schema and it's type is compatible with typ.
+// Reversed types are compatible with direct ones, since they have the same representation.
+func (d *Document) checkField(name string, typ FieldType) error {
+	if d.db == nil {
+		return fmt.Errorf("%w: document has no schema", ErrUnknownField)
+	}
+	fieldType, ok := d.db.schema.fieldType(name)
+	if !ok {
+		return fmt.Errorf("%w: '%v'", ErrUnknownField, name)
+	}
+	if fieldType.direct() != typ {
+		return fmt.Errorf("%w: field '%v' has type %v, not %v", ErrFieldType, name, fieldType, typ)
+	}
+	return nil
+}
+
+// size returns total size of fields values set in the document
+func (d *Document) size() int {
+	if d.db == nil {
+		return 0
+	}
+	var total int
+	add := func(name string, typ FieldType) {
+		if size := typ.size(); size != 0 {
+			total += size
+			return
+		}
+		var size int
+		if d.Get(name, &size) != nil {
+			total += size
+		}
+	}
+	for _, name := range d.db.schema.keysNames {
+		add(name, d.db.schema.keys[name])
+	}
+	for _, name := range d.db.schema.valuesNames {
+		add(name, d.db.schema.values[name])
+	}
+	return total
+}
+
+// key returns identity of the document key: name of the database and values of key fields set in the document
+func (d *Document) key() string {
+	if d.db == nil {
+		return ""
+	}
+	key := binary.AppendUvarint(nil, uint64(len(d.db.name)))
+	key = append(key, d.db.name...)
+	for _, name := range d.db.schema.keysNames {
+		var size int
+		ptr := d.Get(name, &size)
+		key = binary.AppendUvarint(key, uint64(size))
+		if ptr != nil {
+			key = append(key, unsafe.Slice((*byte)(ptr), size)...)
+		}
+	}
+	return string(key)
+}
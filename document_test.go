@@ -0,0 +1,200 @@
+package sophia
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentTypedAccessors(t *testing.T) {
+	const (
+		keyPath    = "key"
+		u8Path     = "u8"
+		u16Path    = "u16"
+		u32Path    = "u32"
+		u64Path    = "u64"
+		bytesPath  = "bytes"
+		expectedU8 = uint8(1 << 7)
+	)
+	var (
+		expectedU16   = uint16(1 << 15)
+		expectedU32   = uint32(1 << 31)
+		expectedU64   = uint64(1 << 63)
+		expectedBytes = []byte{'v', 0, 'a', 0, 'l'}
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(keyPath, FieldTypeString))
+	require.Nil(t, schema.AddValue(u8Path, FieldTypeUInt8))
+	require.Nil(t, schema.AddValue(u16Path, FieldTypeUInt16Rev))
+	require.Nil(t, schema.AddValue(u32Path, FieldTypeUInt32))
+	require.Nil(t, schema.AddValue(u64Path, FieldTypeUInt64))
+	require.Nil(t, schema.AddValue(bytesPath, FieldTypeString))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	doc := db.Document()
+	require.False(t, doc.IsEmpty())
+	require.Nil(t, doc.SetBytes(keyPath, []byte("key")))
+	require.Nil(t, doc.SetUint8(u8Path, expectedU8))
+	require.Nil(t, doc.SetUint16(u16Path, expectedU16))
+	require.Nil(t, doc.SetUint32(u32Path, expectedU32))
+	require.Nil(t, doc.SetUint64(u64Path, expectedU64))
+	require.Nil(t, doc.SetBytes(bytesPath, expectedBytes))
+
+	require.ErrorIs(t, doc.SetUint32("unknown", 1), ErrUnknownField)
+	require.ErrorIs(t, doc.SetUint32(u64Path, 1), ErrFieldType)
+	require.ErrorIs(t, doc.SetBytes(u8Path, nil), ErrFieldType)
+	require.ErrorIs(t, doc.SetUint8(keyPath, 1), ErrFieldType)
+
+	require.Nil(t, db.Set(doc))
+	doc.Free()
+
+	doc = db.Document()
+	require.Nil(t, doc.SetBytes(keyPath, []byte("key")))
+	d, err := db.Get(doc)
+	doc.Free()
+	require.Nil(t, err)
+	require.False(t, d.IsEmpty())
+
+	u8, err := d.GetUint8(u8Path)
+	require.Nil(t, err)
+	require.Equal(t, expectedU8, u8)
+	u16, err := d.GetUint16(u16Path)
+	require.Nil(t, err)
+	require.Equal(t, expectedU16, u16)
+	u32, err := d.GetUint32(u32Path)
+	require.Nil(t, err)
+	require.Equal(t, expectedU32, u32)
+	u64, err := d.GetUint64(u64Path)
+	require.Nil(t, err)
+	require.Equal(t, expectedU64, u64)
+	b, err := d.GetBytes(bytesPath)
+	require.Nil(t, err)
+
+	_, err = d.GetUint64(u32Path)
+	require.ErrorIs(t, err, ErrFieldType)
+	_, err = d.GetBytes("unknown")
+	require.ErrorIs(t, err, ErrUnknownField)
+
+	require.Nil(t, d.Destroy())
+	require.Equal(t, expectedBytes, b)
+}
+
+func TestDocumentWithoutSchema(t *testing.T) {
+	doc := newDocument(nil, 0)
+	require.ErrorIs(t, doc.SetUint32("key", 1), ErrUnknownField)
+	_, err := doc.GetBytes("key")
+	require.ErrorIs(t, err, ErrUnknownField)
+}
+
+func TestDocumentBytes(t *testing.T) {
+	const (
+		keyPath   = "key"
+		valuePath = "value"
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	blob := make([]byte, 4096)
+	for i := range blob {
+		blob[i] = byte(i % 7)
+	}
+	expected := append([]byte{}, blob...)
+
+	doc := db.Document()
+	require.Nil(t, doc.SetBytesUnsafe(keyPath, []byte{0, 1, 0}))
+	require.Nil(t, doc.SetBytesUnsafe(valuePath, blob))
+	require.Nil(t, db.Set(doc))
+	doc.Free()
+	// value has been copied by sophia on Set
+	blob[0] = 0xff
+
+	doc = db.Document()
+	require.True(t, doc.Set(keyPath, []byte{0, 2, 0}))
+	require.Nil(t, doc.SetBytesUnsafe(valuePath, nil))
+	require.Nil(t, db.Set(doc))
+	doc.Free()
+
+	doc = db.Document()
+	require.Nil(t, doc.SetBytesUnsafe(keyPath, []byte{0, 1, 0}))
+	d, err := db.Get(doc)
+	doc.Free()
+	require.Nil(t, err)
+	value, err := d.GetBytesUnsafe(valuePath)
+	require.Nil(t, err)
+	require.Equal(t, expected, value)
+	value, err = d.GetBytes(valuePath)
+	require.Nil(t, err)
+	require.Nil(t, d.Destroy())
+	require.Equal(t, expected, value)
+
+	cursor, err := db.Cursor(db.Document())
+	require.Nil(t, err)
+	var keys, values [][]byte
+	for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+		key, err := d.GetBytesUnsafe(keyPath)
+		require.Nil(t, err)
+		value, err := d.GetBytesUnsafe(valuePath)
+		require.Nil(t, err)
+		keys = append(keys, append([]byte{}, key...))
+		values = append(values, append([]byte{}, value...))
+	}
+	require.Nil(t, cursor.Err())
+	require.Nil(t, cursor.Close())
+	require.Equal(t, [][]byte{{0, 1, 0}, {0, 2, 0}}, keys)
+	require.Equal(t, [][]byte{expected, {}}, values)
+
+	doc = db.Document()
+	require.ErrorIs(t, doc.SetBytesUnsafe("unknown", blob), ErrUnknownField)
+	doc.Free()
+
+	// documents consumed by sophia don't keep memory pinned,
+	// so the runtime doesn't panic if they are garbage collected without Free
+	for i := 0; i < 10; i++ {
+		doc = db.Document()
+		require.Nil(t, doc.SetBytesUnsafe(keyPath, []byte{0, 3, byte(i)}))
+		require.Nil(t, db.Set(doc))
+	}
+	doc = Document{}
+	runtime.GC()
+	runtime.GC()
+	time.Sleep(10 * time.Millisecond)
+}
@@ -3,11 +3,30 @@ package sophia
 import (
 	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/cgo"
+	"strings"
+	"sync"
+	"unsafe"
 )
 
 const errorPath = "sophia.error"
 const EnvironmentPath = "sophia.path"
 
+const (
+	keySchemeTemplate      = "db.%v.scheme"
+	keySchemeFieldTemplate = "db.%v.scheme.%v"
+)
+
+// metaSchemeFields fields which sophia adds to every scheme for internal purposes
+var metaSchemeFields = map[string]struct{}{
+	"_flags": {},
+	"_lsn":   {},
+}
+
 var ErrEnvironmentClosed = errors.New("usage of closed environment")
 
 // Environment is used to configure the database before opening.
@@ -15,6 +34,20 @@ var ErrEnvironmentClosed = errors.New("usage of closed environment")
 // Usually object with same features are called 'database'
 type Environment struct {
 	varStore
+	// mu guards ptr and databases for methods, which can be called concurrently
+	// with Close and NewDatabase: Stats and Databases
+	mu           sync.RWMutex
+	databases    []*Database
+	optionsMu    sync.RWMutex
+	retryPolicy  RetryPolicy
+	batchOptions BatchOptions
+	snapshots    namedSnapshots
+	// handles of go values passed to sophia callbacks
+	handles []cgo.Handle
+	// tracker of live objects, it is set in debug mode
+	tracker *tracker
+	// log receives errors of Go callbacks, it is set by EnvironmentConfig.Logger
+	log *slog.Logger
 }
 
 // NewEnvironment creates a new environment for opening a database.
@@ -24,13 +57,19 @@ func NewEnvironment() (*Environment, error) {
 	if ptr == nil {
 		return nil, errors.New("sp_env failed")
 	}
-	return &Environment{varStore: newVarStore(ptr, 4)}, nil
+	return &Environment{
+		varStore:     newVarStore(ptr, 4),
+		retryPolicy:  DefaultRetryPolicy,
+		batchOptions: DefaultBatchOptions,
+	}, nil
 }
 
 // NewDatabase creates new database in environment with given configuration.
 // At least database's name should be defined. Another options aren't required.
 // Database configuration can't be changed after Environment's Open() was called.
 func (env *Environment) NewDatabase(config DatabaseConfig) (*Database, error) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
 	if env.ptr == nil {
 		return nil, ErrEnvironmentClosed
 	}
@@ -38,28 +77,64 @@ func (env *Environment) NewDatabase(config DatabaseConfig) (*Database, error) {
 	if config.DirectIO && !config.DisableMmapMode {
 		return nil, errors.New("illegal configuration: both direct_io and mmap is enabled")
 	}
+	if config.Upsert != nil && config.UpsertHandler != nil {
+		return nil, errors.New("illegal configuration: both Upsert and UpsertHandler are set")
+	}
+	if (config.Comparator != nil) != (config.ComparatorName != "") {
+		return nil, errors.New("illegal configuration: Comparator and ComparatorName must be set together")
+	}
+
+	if config.Schema == nil {
+		config.Schema = defaultSchema()
+	}
+	if config.Expire {
+		if config.ExpireTTL <= 0 || config.ExpireTTL > math.MaxUint32 {
+			return nil, fmt.Errorf("illegal configuration: expire is enabled with ExpireTTL=%v", config.ExpireTTL)
+		}
+		schema, err := config.Schema.withTimestamp(TimestampField)
+		if err != nil {
+			return nil, err
+		}
+		config.Schema = schema
+	}
+	if n := len(config.Schema.scheme()); n > maxSchemaFields {
+		return nil, fmt.Errorf("illegal configuration: schema has %v fields, maximum is %v", n, maxSchemaFields)
+	}
+	if len(config.UpsertOps) != 0 {
+		if config.Upsert != nil || config.UpsertHandler != nil {
+			return nil, errors.New("illegal configuration: UpsertOps are set with Upsert or UpsertHandler")
+		}
+		if err := config.UpsertOps.validate(config.Schema); err != nil {
+			return nil, fmt.Errorf("illegal configuration: %w", err)
+		}
+		config.UpsertHandler = config.UpsertOps.handler()
+	}
 
 	if !env.SetString("db", config.Name) {
 		return nil, fmt.Errorf("failed to create database: %v", env.Error())
 	}
+	fieldsCount, err := env.initializeSchema(config.Name, config.Schema)
+	if err != nil {
+		return nil, err
+	}
 
-	if config.Schema == nil {
-		config.Schema = defaultSchema()
+	var comparator *comparatorBinding
+	if config.Comparator != nil {
+		comparator = &comparatorBinding{cmp: config.Comparator}
+		if err := env.setComparator(config.Name, comparator); err != nil {
+			return nil, err
+		}
 	}
-	fieldsCount := env.initializeSchema(config.Name, config.Schema)
 
-	if config.Upsert != nil {
-		ptr, index := registerUpsert(config.Upsert)
-		ok := env.Set(fmt.Sprintf(keyUpsertTemplate, config.Name), ptr)
-		if !ok {
-			unregisterUpsert(index)
-			return nil, env.Error()
+	var upsert *upsertBinding
+	if config.Upsert != nil || config.UpsertHandler != nil {
+		upsert = &upsertBinding{
+			fn:      config.Upsert,
+			arg:     upsertArgPointer(config.UpsertArg),
+			handler: config.UpsertHandler,
 		}
-		registerUpsertArg(index, config.UpsertArg)
-		ok = env.Set(fmt.Sprintf(keyUpsertArgTemplate, config.Name), index)
-		if !ok {
-			unregisterUpsert(index)
-			return nil, env.Error()
+		if err := env.setUpsert(config.Name, upsert); err != nil {
+			return nil, err
 		}
 	}
 
@@ -68,6 +143,9 @@ func (env *Environment) NewDatabase(config DatabaseConfig) (*Database, error) {
 	env.SetInt(fmt.Sprintf(keyMmap, config.Name), boolToInt(!config.DisableMmapMode))
 	env.SetInt(fmt.Sprintf(keyDirectIO, config.Name), boolToInt(config.DirectIO))
 	env.SetInt(fmt.Sprintf(keySync, config.Name), boolToInt(!config.DisableSync))
+	if config.Expire {
+		env.SetInt(fmt.Sprintf(keyExpire, config.Name), config.ExpireTTL)
+	}
 
 	env.SetString(fmt.Sprintf(keyCompression, config.Name), config.Compression.String())
 
@@ -75,31 +153,110 @@ func (env *Environment) NewDatabase(config DatabaseConfig) (*Database, error) {
 	if db == nil {
 		return nil, fmt.Errorf("failed to get database object: %v", env.Error())
 	}
-	return &Database{
+	database := &Database{
 		dataStore:   newDataStore(db, env),
 		name:        config.Name,
 		schema:      config.Schema,
 		fieldsCount: fieldsCount,
-	}, nil
+		positions:   config.Schema.positions(),
+		comparator:  config.Comparator,
+
+		comparatorName:  config.ComparatorName,
+		onCallbackError: config.OnCallbackError,
+	}
+	if config.Expire {
+		database.expireTTL = config.ExpireTTL
+	}
+	if upsert != nil {
+		upsert.db = database
+	}
+	if comparator != nil {
+		comparator.db = database
+	}
+	env.databases = append(env.databases, database)
+	return database, nil
+}
+
+// initializeSchema registers schema fields in declaration order,
+// so that compound keys and on-disk fields layout are the same between runs.
+func (env *Environment) initializeSchema(name string, schema *Schema) (int, error) {
+	schemaPath := fmt.Sprintf(keySchemeTemplate, name)
+	fields := schema.scheme()
+	for _, field := range fields {
+		if !env.SetString(schemaPath, field.name) {
+			return 0, fmt.Errorf("failed to add field %v: %v", field, env.Error())
+		}
+		if !env.SetString(fmt.Sprintf(keySchemeFieldTemplate, name, field.name), field.options) {
+			return 0, fmt.Errorf("failed to set field %v: %v", field, env.Error())
+		}
+	}
+	return len(fields), nil
 }
 
-func (env *Environment) initializeSchema(name string, schema *Schema) int {
-	i := 0
-	var schemaPath = fmt.Sprintf("db.%s.scheme", name)
-	for n, typ := range schema.keys {
-		env.SetString(schemaPath, n)
-		keyPath := fmt.Sprintf("db.%s.scheme.%s", name, n)
-		key := fmt.Sprintf("%s,key(%d)", typ.String(), i)
-		env.SetString(keyPath, key)
-		i++
+// validateSchema checks that scheme of opened database is the same as it's Schema.
+// Sophia loads scheme of existing database from disk and ignores configured one,
+// so any difference means that data was written with another Schema.
+func (env *Environment) validateSchema(db *Database) error {
+	actual := env.scheme(db.name)
+	expected := db.schema.scheme()
+	if len(actual) != len(expected) {
+		return fmt.Errorf("database '%v' scheme mismatch: expected %v fields %v, found %v fields %v",
+			db.name, len(expected), expected, len(actual), actual)
 	}
-	for n, typ := range schema.values {
-		env.SetString(schemaPath, n)
-		value := fmt.Sprintf("db.%s.scheme.%s", name, n)
-		env.SetString(value, typ.String())
-		i++
+	for i := range expected {
+		if expected[i] != actual[i] {
+			return fmt.Errorf("database '%v' scheme mismatch: field #%v expected %v, found %v",
+				db.name, i, expected[i], actual[i])
+		}
+	}
+	return nil
+}
+
+// validateExpire checks that ExpireTTL of opened database is the same as persisted one.
+// Sophia loads expire of existing database from disk and ignores configured one.
+func (env *Environment) validateExpire(db *Database) error {
+	actual := env.GetInt(fmt.Sprintf(keyExpire, db.name))
+	if actual != db.expireTTL {
+		return fmt.Errorf("database '%v' expire mismatch: expected ExpireTTL=%v, found %v",
+			db.name, db.expireTTL, actual)
+	}
+	return nil
+}
+
+// scheme reads database scheme from environment configuration.
+// Meta fields added by sophia are skipped.
+func (env *Environment) scheme(name string) []schemeField {
+	prefix := fmt.Sprintf(keySchemeTemplate, name) + "."
+	var fields []schemeField
+	env.iterateConfig(func(key, value string) {
+		if !strings.HasPrefix(key, prefix) {
+			return
+		}
+		field := schemeField{name: strings.TrimPrefix(key, prefix), options: value}
+		if _, ok := metaSchemeFields[field.name]; !ok {
+			fields = append(fields, field)
+		}
+	})
+	return fields
+}
+
+// iterateConfig calls fn for every key of environment configuration
+func (env *Environment) iterateConfig(fn func(key, value string)) {
+	cursor := spGetObject(env.ptr, nil)
+	if cursor == nil {
+		return
+	}
+	defer spDestroy(cursor)
+	var kv unsafe.Pointer
+	for kv = spGet(cursor, kv); kv != nil; kv = spGet(cursor, kv) {
+		var size int
+		key := goString(spGetString(kv, getCStringFromCache("key"), &size))
+		value := ""
+		if ptr := spGetString(kv, getCStringFromCache("value"), &size); ptr != nil {
+			value = goString(ptr)
+		}
+		fn(key, value)
 	}
-	return i
 }
 
 func (env *Environment) configureCompaction(config DatabaseConfig) {
@@ -124,26 +281,58 @@ func (env *Environment) configureCompaction(config DatabaseConfig) {
 	env.SetInt(fmt.Sprintf(keyCompactionPageChecksum, config.Name), boolToInt(!config.DisableCompactionPageChecksum))
 }
 
+// Databases returns databases created in the environment by NewDatabase.
+// It can be called concurrently with NewDatabase and Close.
+func (env *Environment) Databases() []*Database {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	return append([]*Database(nil), env.databases...)
+}
+
 // Close closes the environment and frees its associated memory.
 // You must call Close on any Environment created with NewEnvironment.
+// Named snapshots which are still open are closed too.
+// In debug mode error wrapping ErrLeaked is returned after closing,
+// if there are Documents, Cursors or Transactions, which haven't been released.
 func (env *Environment) Close() error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
 	if env.ptr == nil {
 		return ErrEnvironmentClosed
 	}
+	env.closeNamedSnapshots()
 	env.Free()
 	if !spDestroy(env.ptr) {
 		return fmt.Errorf("failed to close: %v", env.Error())
 	}
 	env.ptr = nil
-	return nil
+	env.releaseHandles()
+	return env.tracker.leaks()
 }
 
 // Open opens environment
 // At a minimum path must be specified and one db declared
+// After opening schemes and ExpireTTL of existing databases are validated against configured ones.
 func (env *Environment) Open() error {
+	existed := make([]bool, len(env.databases))
+	for i, db := range env.databases {
+		_, err := os.Stat(filepath.Join(env.databasePath(db.name), "scheme"))
+		existed[i] = err == nil
+	}
 	if !spOpen(env.ptr) {
 		return env.Error()
 	}
+	for i, db := range env.databases {
+		if err := env.validateSchema(db); err != nil {
+			return err
+		}
+		if err := env.validateExpire(db); err != nil {
+			return err
+		}
+		if err := env.validateComparator(db, existed[i]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -172,9 +361,26 @@ func (env *Environment) BeginTx() (*Transaction, error) {
 	if ptr == nil {
 		return nil, fmt.Errorf("failed to begin transaction: %v", env.Error())
 	}
-	return &Transaction{
+	tx := &Transaction{
 		dataStore: newDataStore(ptr, env),
-	}, nil
+	}
+	tx.live = env.tracker.track("Transaction")
+	return tx, nil
+}
+
+// newHandle creates a handle for a value which is passed to sophia callback.
+// It is valid until the environment is closed.
+func (env *Environment) newHandle(v interface{}) cgo.Handle {
+	handle := cgo.NewHandle(v)
+	env.handles = append(env.handles, handle)
+	return handle
+}
+
+func (env *Environment) releaseHandles() {
+	for _, handle := range env.handles {
+		handle.Delete()
+	}
+	env.handles = nil
 }
 
 func boolToInt(val bool) int64 {
@@ -183,3 +389,11 @@ func boolToInt(val bool) int64 {
 	}
 	return 0
 }
+
+// logger returns logger of the environment, which receives errors of Go callbacks
+func (env *Environment) logger() *slog.Logger {
+	if env.log != nil {
+		return env.log
+	}
+	return slog.Default()
+}
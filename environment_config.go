@@ -0,0 +1,136 @@
+package sophia
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"path/filepath"
+)
+
+const (
+	keyLogEnable     = "log.enable"
+	keyLogPath       = "log.path"
+	keyLogSync       = "log.sync"
+	keyLogRotateWM   = "log.rotate_wm"
+	keyLogRotateSync = "log.rotate_sync"
+)
+
+// EnvironmentConfig a structure for the description of the environment to be created.
+// Zero values of all fields except Path mean sophia defaults.
+//
+// There are no recovery mode and memory limit options: bundled Sophia v2.2 has removed
+// sophia.recover and memory.limit settings, it always recovers in a single phase on Open.
+// Memory used by in-memory indexes is limited per database by DatabaseConfig.CompactionCacheSize.
+type EnvironmentConfig struct {
+	// Path of the directory where sophia keeps databases files. It is required.
+	Path string
+	// DisableLog can be set to disable write-ahead log.
+	// Data which was not written to the database files by checkpoint or compaction
+	// is lost on restart then.
+	DisableLog bool
+	// LogPath of the directory for write-ahead log files. By default it is Path/log.
+	LogPath string
+	// LogSync can be set to sync log file on every commit.
+	LogSync bool
+	// LogRotateWatermark number of writes after which a new log file is created.
+	LogRotateWatermark int64
+	// DisableLogRotateSync can be set to disable sync of the log file on rotation.
+	DisableLogRotateSync bool
+	// BackupPath of the directory where sophia writes backups.
+	// Backup is unavailable if it is not set.
+	BackupPath string
+	// Scheduler configures background scheduler.
+	Scheduler SchedulerConfig
+	// Logger receives sophia internal messages: recovery progress and errors.
+	// Errors are logged with error level and source location attributes, other messages with info level.
+	// It also receives errors of Go callbacks of databases, which don't set DatabaseConfig.OnCallbackError.
+	Logger *slog.Logger
+	// Debug enables tracking of Documents, Cursors and Transactions.
+	// Usage of released object panics, objects which haven't been released
+	// are reported by Close and logged to Logger, when they are garbage collected.
+	// It is intended for tests, since it slows down creation of objects.
+	Debug bool
+}
+
+// NewEnvironmentWithConfig creates a new environment configured with given configuration.
+// Configuration is validated before the environment is created.
+// Receivers must call Close() on the returned Environment.
+func NewEnvironmentWithConfig(config EnvironmentConfig) (*Environment, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	env, err := NewEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	if err := env.configure(config); err != nil {
+		env.Close()
+		return nil, err
+	}
+	return env, nil
+}
+
+func (config EnvironmentConfig) validate() error {
+	if config.Path == "" {
+		return errors.New("illegal configuration: Path is required")
+	}
+	if config.DisableLog {
+		switch {
+		case config.LogPath != "":
+			return errors.New("illegal configuration: log is disabled, but LogPath is set")
+		case config.LogSync:
+			return errors.New("illegal configuration: log is disabled, but LogSync is set")
+		case config.LogRotateWatermark != 0:
+			return errors.New("illegal configuration: log is disabled, but LogRotateWatermark is set")
+		case config.DisableLogRotateSync:
+			return errors.New("illegal configuration: log is disabled, but DisableLogRotateSync is set")
+		}
+	}
+	if config.LogRotateWatermark < 0 || config.LogRotateWatermark > math.MaxUint32 {
+		return fmt.Errorf("illegal configuration: LogRotateWatermark=%v", config.LogRotateWatermark)
+	}
+	if config.BackupPath != "" {
+		backup := filepath.Clean(config.BackupPath)
+		if backup == filepath.Clean(config.Path) {
+			return errors.New("illegal configuration: BackupPath is the same as Path")
+		}
+		if config.LogPath != "" && backup == filepath.Clean(config.LogPath) {
+			return errors.New("illegal configuration: BackupPath is the same as LogPath")
+		}
+	}
+	return config.Scheduler.validate()
+}
+
+func (env *Environment) configure(config EnvironmentConfig) error {
+	settings := map[string]interface{}{
+		EnvironmentPath:  config.Path,
+		keyLogEnable:     boolToInt(!config.DisableLog),
+		keyLogSync:       boolToInt(config.LogSync),
+		keyLogRotateSync: boolToInt(!config.DisableLogRotateSync),
+	}
+	if config.LogPath != "" {
+		settings[keyLogPath] = config.LogPath
+	}
+	if config.LogRotateWatermark != 0 {
+		settings[keyLogRotateWM] = config.LogRotateWatermark
+	}
+	if config.BackupPath != "" {
+		settings[BackupPath] = config.BackupPath
+	}
+	for key, value := range settings {
+		if !env.Set(key, value) {
+			return fmt.Errorf("failed to set %v: %v", key, env.Error())
+		}
+	}
+	if config.Debug {
+		env.tracker = newTracker(config.Logger)
+	}
+	if config.Logger != nil {
+		if !env.setLogger(config.Logger) {
+			return fmt.Errorf("failed to set logger: %v", env.Error())
+		}
+		env.log = config.Logger
+	}
+	return env.SetSchedulerConfig(config.Scheduler)
+}
@@ -0,0 +1,91 @@
+package sophia
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEnvironmentWithIllegalConfig(t *testing.T) {
+	configs := []EnvironmentConfig{
+		{},
+		{Path: "db", DisableLog: true, LogPath: "log"},
+		{Path: "db", DisableLog: true, LogSync: true},
+		{Path: "db", LogRotateWatermark: -1},
+		{Path: "db", BackupPath: "db/"},
+		{Path: "db", LogPath: "log", BackupPath: "log"},
+		{Path: "db", Scheduler: SchedulerConfig{Threads: -1}},
+		{Path: "db", Scheduler: SchedulerConfig{Threads: 2, Manual: true}},
+	}
+	for _, config := range configs {
+		env, err := NewEnvironmentWithConfig(config)
+		require.Error(t, err, "%+v", config)
+		require.Nil(t, env)
+	}
+}
+
+func TestNewEnvironmentWithConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	logPath := filepath.Join(tmpDir, "wal")
+	env, err := NewEnvironmentWithConfig(EnvironmentConfig{
+		Path:               filepath.Join(tmpDir, "data"),
+		LogPath:            logPath,
+		LogSync:            true,
+		LogRotateWatermark: 1000,
+		Scheduler:          SchedulerConfig{Manual: true},
+	})
+	require.Nil(t, err)
+
+	db, err := env.NewDatabase(DatabaseConfig{Name: "test_database"})
+	require.Nil(t, err)
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	require.Equal(t, int64(1), env.GetInt(keyLogSync))
+	require.Equal(t, int64(1000), env.GetInt(keyLogRotateWM))
+	require.Equal(t, int64(0), env.GetInt(keySchedulerThreads))
+
+	doc := db.Document()
+	doc.Set("key", fmt.Sprint(1))
+	doc.Set("value", fmt.Sprint(1))
+	require.Nil(t, db.Set(doc))
+	doc.Free()
+
+	logs, err := filepath.Glob(filepath.Join(logPath, "*.log"))
+	require.Nil(t, err)
+	require.NotEmpty(t, logs)
+}
+
+func TestEnvironmentConfigLogger(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	env, err := NewEnvironmentWithConfig(EnvironmentConfig{
+		Path:   tmpDir,
+		Logger: logger,
+	})
+	require.Nil(t, err)
+
+	_, err = env.NewDatabase(DatabaseConfig{Name: "test_database"})
+	require.Nil(t, err)
+	require.Nil(t, env.Open())
+
+	require.False(t, env.SetInt("unknown.key", 1))
+	require.Nil(t, env.Close())
+
+	out := buf.String()
+	require.Contains(t, out, "level=INFO msg=\"recovering repository")
+	require.Regexp(t, `level=ERROR msg=.*unknown\.key.* file=\S+ line=\d+`, out)
+}
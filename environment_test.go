@@ -1,6 +1,7 @@
 package sophia
 
 import (
+	"fmt"
 	"testing"
 
 	"io/ioutil"
@@ -40,6 +41,25 @@ func TestEnvironmentNewDatabaseIllegalConfig(t *testing.T) {
 	require.Nil(t, db)
 }
 
+func TestEnvironmentNewDatabaseTooManyFields(t *testing.T) {
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+	defer env.Close()
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey("key", FieldTypeUInt32))
+	for i := 0; i < maxSchemaFields; i++ {
+		require.Nil(t, schema.AddValue(fmt.Sprintf("value%v", i), FieldTypeUInt32))
+	}
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test",
+		Schema: schema,
+	})
+	require.NotNil(t, err)
+	require.Nil(t, db)
+}
+
 func TestEnvironmentNewDatabaseIllegalName(t *testing.T) {
 	env, err := NewEnvironment()
 	require.Nil(t, err)
@@ -109,3 +129,75 @@ func TestEnvironmentCloseTwice(t *testing.T) {
 	require.Nil(t, env.Close())
 	require.NotNil(t, env.Close())
 }
+
+func TestEnvironmentSchemaOrder(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "sophia")
+	require.Nil(t, err)
+	defer os.RemoveAll(dbPath)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.Set(EnvironmentPath, dbPath))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey("c", FieldTypeUInt32))
+	require.Nil(t, schema.AddKey("b", FieldTypeString))
+	require.Nil(t, schema.AddKey("a", FieldTypeUInt8))
+	require.Nil(t, schema.AddValue("z", FieldTypeString))
+	require.Nil(t, schema.AddValue("y", FieldTypeUInt64))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	require.Equal(t, []schemeField{
+		{name: "c", options: "u32,key(0)"},
+		{name: "b", options: "string,key(1)"},
+		{name: "a", options: "u8,key(2)"},
+		{name: "z", options: "string"},
+		{name: "y", options: "u64"},
+	}, env.scheme("test"))
+}
+
+func TestEnvironmentReopenWithSchema(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "sophia")
+	require.Nil(t, err)
+	defer os.RemoveAll(dbPath)
+
+	open := func(keyType FieldType) error {
+		env, err := NewEnvironment()
+		require.Nil(t, err)
+		require.NotNil(t, env)
+		defer env.Close()
+
+		require.True(t, env.Set(EnvironmentPath, dbPath))
+
+		schema := &Schema{}
+		require.Nil(t, schema.AddKey("key", keyType))
+		require.Nil(t, schema.AddValue("value", FieldTypeString))
+
+		db, err := env.NewDatabase(DatabaseConfig{
+			Name:   "test",
+			Schema: schema,
+		})
+		require.Nil(t, err)
+		require.NotNil(t, db)
+		return env.Open()
+	}
+
+	require.Nil(t, open(FieldTypeUInt32))
+	require.Nil(t, open(FieldTypeUInt32))
+
+	err = open(FieldTypeUInt64)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "scheme mismatch")
+	require.Contains(t, err.Error(), "u32,key(0)")
+}
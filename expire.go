@@ -0,0 +1,45 @@
+package sophia
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TimestampField name of value field, that is added to schema of database with enabled expire.
+// It contains unix time in seconds, from which document TTL is counted.
+const TimestampField = "timestamp"
+
+// ErrExpireDisabled will be returned in case of TTL usage for database without expire
+var ErrExpireDisabled = errors.New("expire is not enabled for database")
+
+// SetTTL sets time to live of document.
+// TTL can't be greater than database ExpireTTL,
+// because sophia counts it from TimestampField value, which can't be set in the future.
+func (d *Document) SetTTL(ttl time.Duration) error {
+	return d.SetExpireAt(time.Now().Add(ttl))
+}
+
+// SetExpireAt sets time after which document will be expired.
+// Document is removed on the first expire compaction after this time.
+func (d *Document) SetExpireAt(t time.Time) error {
+	if d.db == nil || d.db.expireTTL == 0 {
+		return ErrExpireDisabled
+	}
+	timestamp := t.Unix() - d.db.expireTTL
+	if timestamp > time.Now().Unix() {
+		return fmt.Errorf("expiration time %v exceeds database ExpireTTL=%vs", t, d.db.expireTTL)
+	}
+	if timestamp < 0 {
+		timestamp = 0
+	}
+	return d.SetUint32(TimestampField, uint32(timestamp))
+}
+
+// SetWithTTL sets the row of the set of keys with given time to live.
+func (db *Database) SetWithTTL(doc Document, ttl time.Duration) error {
+	if err := doc.SetTTL(ttl); err != nil {
+		return err
+	}
+	return db.Set(doc)
+}
@@ -0,0 +1,174 @@
+package sophia
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseExpire(t *testing.T) {
+	const (
+		keyPath      = "key"
+		valuePath    = "value"
+		recordsCount = 10
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(keyPath, FieldTypeUInt32))
+	require.Nil(t, schema.AddValue(valuePath, FieldTypeString))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:                   "test_database",
+		Schema:                 schema,
+		Expire:                 true,
+		ExpireTTL:              3600,
+		CompactionExpirePeriod: 1,
+		// flush in-memory index to disk as soon as possible,
+		// because only node files are checked for expired documents
+		CompactionCacheSize: 1,
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	for i := 0; i < recordsCount; i++ {
+		doc := db.Document()
+		require.Nil(t, doc.SetUint32(keyPath, uint32(i)))
+		require.Nil(t, doc.SetBytes(valuePath, []byte("value")))
+		if i%2 == 0 {
+			require.Nil(t, db.SetWithTTL(doc, time.Second))
+		} else {
+			require.Nil(t, db.Set(doc))
+		}
+		doc.Free()
+	}
+
+	keys := func() []uint32 {
+		doc := db.Document()
+		cursor, err := db.Cursor(doc)
+		require.Nil(t, err)
+		defer cursor.Close()
+		var keys []uint32
+		for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+			key, err := d.GetUint32(keyPath)
+			require.Nil(t, err)
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	require.Len(t, keys(), recordsCount)
+	require.Eventually(t, func() bool {
+		return len(keys()) == recordsCount/2
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Equal(t, []uint32{1, 3, 5, 7, 9}, keys())
+}
+
+func TestDocumentTTL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	_, err = env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Expire: true,
+	})
+	require.NotNil(t, err)
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(TimestampField, FieldTypeUInt32))
+	_, err = env.NewDatabase(DatabaseConfig{
+		Name:      "test_database",
+		Schema:    schema,
+		Expire:    true,
+		ExpireTTL: 60,
+	})
+	require.NotNil(t, err)
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:      "test_expire",
+		Expire:    true,
+		ExpireTTL: 60,
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+	require.Len(t, defaultSchema().valuesNames, 1)
+
+	noExpireDB, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_no_expire",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, noExpireDB)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	doc := db.Document()
+	defer doc.Free()
+	require.Nil(t, doc.SetTTL(time.Second))
+	require.Nil(t, doc.SetTTL(time.Minute))
+	require.NotNil(t, doc.SetTTL(time.Hour))
+	require.Nil(t, doc.SetExpireAt(time.Unix(0, 0)))
+
+	now := time.Now()
+	require.Nil(t, doc.SetExpireAt(now.Add(30*time.Second)))
+	timestamp, err := doc.GetUint32(TimestampField)
+	require.Nil(t, err)
+	require.Equal(t, uint32(now.Unix()-30), timestamp)
+
+	noExpireDoc := noExpireDB.Document()
+	defer noExpireDoc.Free()
+	require.Equal(t, ErrExpireDisabled, noExpireDoc.SetTTL(time.Second))
+}
+
+func TestDatabaseReopenWithExpire(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "sophia")
+	require.Nil(t, err)
+	defer os.RemoveAll(dbPath)
+
+	open := func(ttl int64) error {
+		env, err := NewEnvironment()
+		require.Nil(t, err)
+		require.NotNil(t, env)
+		defer env.Close()
+
+		require.True(t, env.Set(EnvironmentPath, dbPath))
+
+		db, err := env.NewDatabase(DatabaseConfig{
+			Name:      "test",
+			Expire:    true,
+			ExpireTTL: ttl,
+		})
+		require.Nil(t, err)
+		require.NotNil(t, db)
+		return env.Open()
+	}
+
+	require.Nil(t, open(3600))
+	require.Nil(t, open(3600))
+
+	err = open(60)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "expire mismatch")
+	require.Contains(t, err.Error(), "found 3600")
+}
@@ -0,0 +1,41 @@
+package sophia
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"runtime/cgo"
+	"unsafe"
+)
+
+/*
+extern void goLogCall(char *message, void *arg);
+*/
+import "C"
+
+const (
+	keyOnLog    = "sophia.on_log"
+	keyOnLogArg = "sophia.on_log_arg"
+)
+
+// errorMessage matches messages of sophia errors, which are prefixed with source location
+var errorMessage = regexp.MustCompile(`^(\S+\.[ch]):(\d+) (.*)$`)
+
+//export goLogCall
+func goLogCall(message *C.char, arg unsafe.Pointer) {
+	logger := cgo.Handle(uintptr(arg)).Value().(*slog.Logger)
+	msg := C.GoString(message)
+	if m := errorMessage.FindStringSubmatch(msg); m != nil {
+		logger.LogAttrs(context.Background(), slog.LevelError, m[3],
+			slog.String("file", m[1]), slog.String("line", m[2]))
+		return
+	}
+	logger.LogAttrs(context.Background(), slog.LevelInfo, msg)
+}
+
+// setLogger passes sophia log messages to logger.
+// Messages of errors are logged with error level, all others with info level.
+func (env *Environment) setLogger(logger *slog.Logger) bool {
+	arg := handlePointer(env.newHandle(logger))
+	return env.Set(keyOnLog, C.goLogCall) && env.Set(keyOnLogArg, arg)
+}
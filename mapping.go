@@ -0,0 +1,287 @@
+package sophia
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// tagName name of struct tag that describes mapping of struct field to document field.
+// Format of tag is `sophia:"name,role,type"`, where
+//   - name is a name of document field, Go field name is used if it is empty;
+//   - role is 'key' or 'value' (default);
+//   - type is a name of FieldType ('u8', 'u32_rev', 'string', etc.),
+//     by default it is derived from Go type of field.
+//
+// Fields with tag `sophia:"-"` and unexported fields are skipped.
+const tagName = "sophia"
+
+const (
+	tagKey   = "key"
+	tagValue = "value"
+	tagSkip  = "-"
+)
+
+// structField describes mapping of a single struct field to a document field
+type structField struct {
+	index int
+	name  string
+	key   bool
+	typ   FieldType
+}
+
+var structCache = map[reflect.Type][]structField{}
+var structLock sync.RWMutex
+
+// SchemaFromStruct creates Schema from struct type using `sophia` tags.
+// Keys and values are added in the order they are declared in struct.
+func SchemaFromStruct(v interface{}) (*Schema, error) {
+	fields, err := structFieldsOf(reflect.TypeOf(v))
+	if err != nil {
+		return nil, err
+	}
+	schema := &Schema{}
+	for _, f := range fields {
+		if f.key {
+			err = schema.AddKey(f.name, f.typ)
+		} else {
+			err = schema.AddValue(f.name, f.typ)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(schema.keysNames) == 0 {
+		return nil, errors.New("struct has no key fields")
+	}
+	return schema, nil
+}
+
+// Marshal fills document fields from struct or pointer to struct.
+// Every field is validated against database schema.
+func (d *Document) Marshal(v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return errors.New("marshal: nil pointer")
+		}
+		val = val.Elem()
+	}
+	fields, err := structFieldsOf(val.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err := d.marshalField(f, val.Field(f.index)); err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+	}
+	return nil
+}
+
+// Unmarshal decodes document fields to struct, v should be a pointer to struct.
+// All string values are copied, so v remains valid after Document Destroy() call.
+func (d *Document) Unmarshal(v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return errors.New("unmarshal: non-nil pointer to struct expected")
+	}
+	val = val.Elem()
+	fields, err := structFieldsOf(val.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err := d.unmarshalField(f, val.Field(f.index)); err != nil {
+			return fmt.Errorf("unmarshal: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d *Document) marshalField(f structField, val reflect.Value) error {
+	if f.typ == FieldTypeString {
+		if val.Kind() == reflect.String {
+			return d.SetBytes(f.name, []byte(val.String()))
+		}
+		return d.SetBytes(f.name, val.Bytes())
+	}
+
+	var u uint64
+	switch val.Kind() {
+	case reflect.Bool:
+		if val.Bool() {
+			u = 1
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// signed values are stored in two's complement form of the Go type width
+		u = uint64(val.Int()) & (1<<uint(val.Type().Bits()) - 1)
+	default:
+		u = val.Uint()
+	}
+	if size := f.typ.size(); size < 8 && u >= 1<<uint(size*8) {
+		return fmt.Errorf("value %v of field '%v' overflows %v", u, f.name, f.typ)
+	}
+	return d.setUint(f.name, u, f.typ.direct())
+}
+
+func (d *Document) unmarshalField(f structField, val reflect.Value) error {
+	if f.typ == FieldTypeString {
+		b, err := d.GetBytes(f.name)
+		if err != nil {
+			return err
+		}
+		if val.Kind() == reflect.String {
+			val.SetString(string(b))
+		} else {
+			val.SetBytes(b)
+		}
+		return nil
+	}
+
+	var u uint64
+	switch f.typ.direct() {
+	case FieldTypeUInt8:
+		v, err := d.GetUint8(f.name)
+		if err != nil {
+			return err
+		}
+		u = uint64(v)
+	case FieldTypeUInt16:
+		v, err := d.GetUint16(f.name)
+		if err != nil {
+			return err
+		}
+		u = uint64(v)
+	case FieldTypeUInt32:
+		v, err := d.GetUint32(f.name)
+		if err != nil {
+			return err
+		}
+		u = uint64(v)
+	default:
+		v, err := d.GetUint64(f.name)
+		if err != nil {
+			return err
+		}
+		u = v
+	}
+
+	switch val.Kind() {
+	case reflect.Bool:
+		val.SetBool(u != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// restore sign from two's complement form of the Go type width
+		shift := uint(64 - val.Type().Bits())
+		val.SetInt(int64(u<<shift) >> shift)
+	default:
+		if val.OverflowUint(u) {
+			return fmt.Errorf("value %v of field '%v' overflows %v", u, f.name, val.Type())
+		}
+		val.SetUint(u)
+	}
+	return nil
+}
+
+// structFieldsOf returns cached mapping of struct type fields
+func structFieldsOf(typ reflect.Type) ([]structField, error) {
+	if typ == nil {
+		return nil, errors.New("struct expected, got nil")
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("struct expected, got %v", typ)
+	}
+
+	structLock.RLock()
+	fields, ok := structCache[typ]
+	structLock.RUnlock()
+	if ok {
+		return fields, nil
+	}
+
+	fields, err := parseStruct(typ)
+	if err != nil {
+		return nil, err
+	}
+	structLock.Lock()
+	structCache[typ] = fields
+	structLock.Unlock()
+	return fields, nil
+}
+
+func parseStruct(typ reflect.Type) ([]structField, error) {
+	fields := make([]structField, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get(tagName)
+		if sf.PkgPath != "" || tag == tagSkip {
+			continue
+		}
+		f, err := parseField(sf, tag)
+		if err != nil {
+			return nil, fmt.Errorf("field %v.%v: %w", typ.Name(), sf.Name, err)
+		}
+		f.index = i
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func parseField(sf reflect.StructField, tag string) (structField, error) {
+	parts := strings.Split(tag, ",")
+	f := structField{name: parts[0]}
+	if f.name == "" {
+		f.name = sf.Name
+	}
+
+	defaultType, ok := defaultFieldType(sf.Type)
+	if !ok {
+		return f, fmt.Errorf("unsupported type %v", sf.Type)
+	}
+	f.typ = defaultType
+	for _, part := range parts[1:] {
+		switch part {
+		case tagKey:
+			f.key = true
+		case tagValue, "":
+		default:
+			typ, ok := fieldTypeByName(part)
+			if !ok {
+				return f, fmt.Errorf("unknown tag option '%v'", part)
+			}
+			if (typ == FieldTypeString) != (defaultType == FieldTypeString) {
+				return f, fmt.Errorf("type %v can't be stored as %v", sf.Type, typ)
+			}
+			f.typ = typ
+		}
+	}
+	return f, nil
+}
+
+// defaultFieldType returns FieldType for Go type.
+// Signed integers are mapped to unsigned types of the same width,
+// so their order in keys isn't preserved for negative values.
+func defaultFieldType(typ reflect.Type) (FieldType, bool) {
+	switch typ.Kind() {
+	case reflect.Bool, reflect.Uint8, reflect.Int8:
+		return FieldTypeUInt8, true
+	case reflect.Uint16, reflect.Int16:
+		return FieldTypeUInt16, true
+	case reflect.Uint32, reflect.Int32:
+		return FieldTypeUInt32, true
+	case reflect.Uint64, reflect.Uint, reflect.Uintptr, reflect.Int64, reflect.Int:
+		return FieldTypeUInt64, true
+	case reflect.String:
+		return FieldTypeString, true
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return FieldTypeString, true
+		}
+	}
+	return 0, false
+}
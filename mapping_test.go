@@ -0,0 +1,124 @@
+package sophia
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mappingRecord struct {
+	ID      uint32 `sophia:"id,key"`
+	Name    string `sophia:"name,key"`
+	Count   int16  `sophia:"count,value,u32"`
+	Total   int64
+	Enabled bool   `sophia:"enabled"`
+	Payload []byte `sophia:"payload,value"`
+	Skipped string `sophia:"-"`
+	hidden  string
+}
+
+func TestSchemaFromStruct(t *testing.T) {
+	schema, err := SchemaFromStruct(mappingRecord{})
+	require.Nil(t, err)
+	require.Equal(t, []schemeField{
+		{name: "id", options: "u32,key(0)"},
+		{name: "name", options: "string,key(1)"},
+		{name: "count", options: "u32"},
+		{name: "Total", options: "u64"},
+		{name: "enabled", options: "u8"},
+		{name: "payload", options: "string"},
+	}, schema.scheme())
+
+	_, err = SchemaFromStruct(&mappingRecord{})
+	require.Nil(t, err)
+
+	_, err = SchemaFromStruct(struct {
+		Value string
+	}{})
+	require.NotNil(t, err)
+
+	_, err = SchemaFromStruct(struct {
+		Key float64 `sophia:"key,key"`
+	}{})
+	require.NotNil(t, err)
+
+	_, err = SchemaFromStruct(struct {
+		Key string `sophia:"key,key,u32"`
+	}{})
+	require.NotNil(t, err)
+
+	_, err = SchemaFromStruct(struct {
+		Key string `sophia:"key,primary"`
+	}{})
+	require.NotNil(t, err)
+
+	_, err = SchemaFromStruct(42)
+	require.NotNil(t, err)
+}
+
+func TestDocumentMarshalUnmarshal(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema, err := SchemaFromStruct(mappingRecord{})
+	require.Nil(t, err)
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	expected := mappingRecord{
+		ID:      42,
+		Name:    "name",
+		Count:   -73,
+		Total:   -1 << 40,
+		Enabled: true,
+		Payload: []byte{1, 0, 2},
+	}
+
+	doc := db.Document()
+	require.Nil(t, doc.Marshal(&expected))
+	require.Nil(t, db.Set(doc))
+	doc.Free()
+
+	doc = db.Document()
+	require.Nil(t, doc.Marshal(mappingRecord{ID: expected.ID, Name: expected.Name}))
+	d, err := db.Get(doc)
+	doc.Free()
+	require.Nil(t, err)
+
+	var actual mappingRecord
+	require.Nil(t, d.Unmarshal(&actual))
+	require.Nil(t, d.Destroy())
+	require.Equal(t, expected, actual)
+
+	require.NotNil(t, d.Unmarshal(actual))
+	require.NotNil(t, d.Unmarshal((*mappingRecord)(nil)))
+
+	doc = db.Document()
+	defer doc.Free()
+	require.ErrorIs(t, doc.Marshal(struct {
+		Unknown uint32 `sophia:"unknown,key"`
+	}{}), ErrUnknownField)
+	require.ErrorIs(t, doc.Marshal(struct {
+		ID uint64 `sophia:"id,key"`
+	}{}), ErrFieldType)
+	require.NotNil(t, doc.Marshal(struct {
+		ID uint64 `sophia:"id,key,u32"`
+	}{ID: 1 << 32}))
+}
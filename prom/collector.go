@@ -0,0 +1,196 @@
+// Package prom provides prometheus collector of sophia statistics.
+package prom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	sophia "github.com/pzhin/go-sophia"
+)
+
+const namespace = "sophia"
+
+var (
+	lsnDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "lsn"),
+		"Current log sequence number.",
+		nil, nil)
+	backupSequenceDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "bsn"),
+		"Current backup sequence number.",
+		nil, nil)
+	logFilesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "log", "files"),
+		"Number of log files.",
+		nil, nil)
+	txActiveDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "transaction", "active"),
+		"Number of active transactions.",
+		[]string{"type"}, nil)
+	txTotalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "transaction", "total"),
+		"Number of completed transactions and their outcomes.",
+		[]string{"status"}, nil)
+	txLatencyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "transaction", "latency_seconds"),
+		"Latency of transactions.",
+		[]string{"stat"}, nil)
+	txGCDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "transaction", "gc_versions"),
+		"Number of versions waiting for garbage collection.",
+		nil, nil)
+
+	dbLabels        = []string{"database"}
+	dbDocumentsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "documents"),
+		"Total number of documents in database.",
+		dbLabels, nil)
+	dbDuplicatesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "duplicates"),
+		"Number of document versions waiting for compaction.",
+		dbLabels, nil)
+	dbMemoryDocumentsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "memory_documents"),
+		"Number of documents in memory, which haven't been compacted to disk yet.",
+		dbLabels, nil)
+	dbMemoryBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "memory_bytes"),
+		"Memory used by database.",
+		[]string{"database", "kind"}, nil)
+	dbSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "size_bytes"),
+		"Size of database files.",
+		dbLabels, nil)
+	dbSizeUncompressedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "size_uncompressed_bytes"),
+		"Size of database files without compression.",
+		dbLabels, nil)
+	dbNodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "nodes"),
+		"Number of database nodes.",
+		dbLabels, nil)
+	dbPagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "pages"),
+		"Number of database pages.",
+		dbLabels, nil)
+	dbReadsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "reads_total"),
+		"Number of page reads by source.",
+		[]string{"database", "source"}, nil)
+	dbOperationsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "operations_total"),
+		"Number of database operations.",
+		[]string{"database", "operation"}, nil)
+	dbLatencyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "db", "operation_latency_seconds"),
+		"Latency of database operations.",
+		[]string{"database", "operation", "stat"}, nil)
+)
+
+// Collector is a prometheus.Collector which exports statistics of
+// sophia Environment and of every its Database, labeled by database name.
+type Collector struct {
+	env *sophia.Environment
+}
+
+// NewCollector creates Collector for the environment.
+// Databases are taken from the environment on every collection.
+// Collection is safe concurrently with NewDatabase and Close of the environment,
+// but the collector should be unregistered before Close, otherwise scrapes fail.
+func NewCollector(env *sophia.Environment) *Collector {
+	return &Collector{env: env}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		lsnDesc, backupSequenceDesc, logFilesDesc,
+		txActiveDesc, txTotalDesc, txLatencyDesc, txGCDesc,
+		dbDocumentsDesc, dbDuplicatesDesc, dbMemoryDocumentsDesc, dbMemoryBytesDesc,
+		dbSizeDesc, dbSizeUncompressedDesc, dbNodesDesc, dbPagesDesc,
+		dbReadsDesc, dbOperationsDesc, dbLatencyDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.env.Stats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(lsnDesc, err)
+		return
+	}
+	gauge(ch, lsnDesc, stats.LSN)
+	gauge(ch, backupSequenceDesc, stats.BSN)
+	gauge(ch, logFilesDesc, stats.LogFiles)
+
+	tx := stats.Transactions
+	gauge(ch, txActiveDesc, tx.OnlineRW, "rw")
+	gauge(ch, txActiveDesc, tx.OnlineRO, "ro")
+	counter(ch, txTotalDesc, tx.Commits, "commit")
+	counter(ch, txTotalDesc, tx.Rollbacks, "rollback")
+	counter(ch, txTotalDesc, tx.Conflicts, "conflict")
+	counter(ch, txTotalDesc, tx.Locks, "lock")
+	latency(ch, txLatencyDesc, tx.Latency)
+	gauge(ch, txGCDesc, tx.GC)
+
+	for _, db := range c.env.Databases() {
+		c.collectDatabase(ch, db)
+	}
+}
+
+func (c *Collector) collectDatabase(ch chan<- prometheus.Metric, db *sophia.Database) {
+	name := db.Name()
+	stats, err := db.Stats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(dbDocumentsDesc, err)
+		return
+	}
+	gauge(ch, dbDocumentsDesc, stats.Count, name)
+	gauge(ch, dbDuplicatesDesc, stats.CountDup, name)
+	gauge(ch, dbMemoryDocumentsDesc, stats.Documents, name)
+	gauge(ch, dbMemoryBytesDesc, stats.DocumentsUsed, name, "documents")
+	gauge(ch, dbMemoryBytesDesc, stats.MemoryUsed, name, "index")
+	gauge(ch, dbSizeDesc, stats.Size, name)
+	gauge(ch, dbSizeUncompressedDesc, stats.SizeUncompressed, name)
+	gauge(ch, dbNodesDesc, stats.NodeCount, name)
+	gauge(ch, dbPagesDesc, stats.PageCount, name)
+	counter(ch, dbReadsDesc, stats.ReadDisk, name, "disk")
+	counter(ch, dbReadsDesc, stats.ReadCache, name, "cache")
+
+	for _, op := range []struct {
+		name  string
+		stats sophia.OperationStats
+	}{
+		{"set", stats.Set},
+		{"delete", stats.Delete},
+		{"upsert", stats.Upsert},
+		{"get", stats.Get},
+		{"cursor", stats.Cursor},
+		{"pread", stats.PRead},
+	} {
+		counter(ch, dbOperationsDesc, op.stats.Count, name, op.name)
+		latency(ch, dbLatencyDesc, op.stats.Latency, name, op.name)
+	}
+}
+
+func gauge(ch chan<- prometheus.Metric, desc *prometheus.Desc, value int64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), labels...)
+}
+
+func counter(ch chan<- prometheus.Metric, desc *prometheus.Desc, value int64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), labels...)
+}
+
+func latency(ch chan<- prometheus.Metric, desc *prometheus.Desc, l sophia.Latency, labels ...string) {
+	for _, stat := range []struct {
+		name  string
+		value float64
+	}{
+		{"min", l.Min.Seconds()},
+		{"max", l.Max.Seconds()},
+		{"avg", l.Avg.Seconds()},
+	} {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, stat.value, append(labels, stat.name)...)
+	}
+}
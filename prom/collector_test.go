@@ -0,0 +1,101 @@
+package prom
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	sophia "github.com/pzhin/go-sophia"
+)
+
+func TestCollector(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := sophia.NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(sophia.EnvironmentPath, tmpDir))
+
+	db, err := env.NewDatabase(sophia.DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	doc := db.Document()
+	require.True(t, doc.Set("key", "key1"))
+	require.True(t, doc.Set("value", "value1"))
+	require.Nil(t, db.Set(doc))
+	doc.Free()
+
+	collector := NewCollector(env)
+	registry := prometheus.NewPedanticRegistry()
+	require.Nil(t, registry.Register(collector))
+
+	expected := `
+# HELP sophia_db_documents Total number of documents in database.
+# TYPE sophia_db_documents gauge
+sophia_db_documents{database="test_database"} 1
+# HELP sophia_db_operations_total Number of database operations.
+# TYPE sophia_db_operations_total counter
+sophia_db_operations_total{database="test_database",operation="cursor"} 0
+sophia_db_operations_total{database="test_database",operation="delete"} 0
+sophia_db_operations_total{database="test_database",operation="get"} 0
+sophia_db_operations_total{database="test_database",operation="pread"} 0
+sophia_db_operations_total{database="test_database",operation="set"} 1
+sophia_db_operations_total{database="test_database",operation="upsert"} 0
+`
+	require.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"sophia_db_documents", "sophia_db_operations_total"))
+	count, err := testutil.GatherAndCount(registry)
+	require.Nil(t, err)
+	require.True(t, count > 0)
+}
+
+func TestCollectorClose(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := sophia.NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(sophia.EnvironmentPath, tmpDir))
+
+	_, err = env.NewDatabase(sophia.DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.Nil(t, env.Open())
+
+	registry := prometheus.NewPedanticRegistry()
+	require.Nil(t, registry.Register(NewCollector(env)))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, err := registry.Gather(); err != nil {
+				return
+			}
+		}
+	}()
+	require.Nil(t, env.Close())
+	<-done
+
+	_, err = registry.Gather()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), sophia.ErrEnvironmentClosed.Error())
+}
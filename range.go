@@ -0,0 +1,333 @@
+package sophia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+// maxKeyStringSize is a maximum size of string key field, it is sophia db.<name>.limit.key
+const maxKeyStringSize = 1024
+
+// Key is a value of compound key, values are matched to key fields in schema order.
+// Numeric fields accept unsigned and non-negative signed integers, string fields accept string and []byte.
+// Key can be shorter than the compound key.
+type Key []interface{}
+
+// RangeOptions describes range of documents to iterate over
+type RangeOptions struct {
+	// Start key of the range. If it is not set, iteration starts from the first document.
+	// Start can be shorter than the compound key, then documents are compared by its prefix,
+	// so GT and LT skip all documents with the same prefix and GTE and LTE include them.
+	Start Key
+	// End key of the range, it is not included. If it is not set, iteration continues till the last document.
+	End Key
+	// Prefix of the first key field, which must be a string.
+	Prefix []byte
+	// Order of comparison with Start: GTE (default) or GT for direct iteration, LTE (default) or LT for Reverse.
+	Order Order
+	// Limit maximum number of documents, zero means no limit.
+	Limit int
+	// Reverse iterates from the greatest keys to the least ones.
+	Reverse bool
+}
+
+// Range returns an iterator over documents in the range.
+// Yielded Document is valid only until the next iteration, an error stops iteration.
+func (db *Database) Range(opts RangeOptions) iter.Seq2[Document, error] {
+	return db.RangeContext(context.Background(), opts)
+}
+
+// RangeContext is like Range, but ctx is checked between documents,
+// iteration is stopped with ctx error when it is done.
+func (db *Database) RangeContext(ctx context.Context, opts RangeOptions) iter.Seq2[Document, error] {
+	return func(yield func(Document, error) bool) {
+		start, end, err := db.validateRange(&opts)
+		if err != nil {
+			yield(Document{}, err)
+			return
+		}
+		seek, order, empty := db.rangeStart(start, opts.Order)
+		if empty {
+			return
+		}
+		// start can't be completed for Comparator, so documents before it are skipped
+		skip := seek == nil && start != nil
+		doc, err := db.rangeDocument(opts.Prefix, seek, order)
+		if err != nil {
+			yield(Document{}, err)
+			return
+		}
+		cursor, err := db.Cursor(doc)
+		if err != nil {
+			doc.Free()
+			yield(Document{}, err)
+			return
+		}
+		defer cursor.Close()
+
+		count := 0
+		for d := cursor.NextContext(ctx); !d.IsEmpty(); d = cursor.NextContext(ctx) {
+			if skip {
+				if db.beforeStart(d, start, opts.Order) {
+					continue
+				}
+				skip = false
+			}
+			if end != nil {
+				c := db.compareKey(d, end)
+				if c >= 0 && !opts.Reverse || c <= 0 && opts.Reverse {
+					return
+				}
+			}
+			if !yield(d, nil) {
+				return
+			}
+			count++
+			if count == opts.Limit {
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			yield(Document{}, err)
+		}
+	}
+}
+
+// validateRange checks options against schema, sets default order and returns encoded Start and End
+func (db *Database) validateRange(opts *RangeOptions) (start, end [][]byte, err error) {
+	if opts.Limit < 0 {
+		return nil, nil, fmt.Errorf("illegal range: negative Limit=%v", opts.Limit)
+	}
+	switch opts.Order {
+	case "":
+		opts.Order = GTE
+		if opts.Reverse {
+			opts.Order = LTE
+		}
+	case GT, GTE:
+		if opts.Reverse {
+			return nil, nil, fmt.Errorf("illegal range: order %v is set for reverse iteration", opts.Order)
+		}
+	case LT, LTE:
+		if !opts.Reverse {
+			return nil, nil, fmt.Errorf("illegal range: order %v is set for direct iteration", opts.Order)
+		}
+	default:
+		return nil, nil, fmt.Errorf("illegal range: unknown order %v", opts.Order)
+	}
+	if len(opts.Prefix) != 0 && db.schema.keys[db.schema.keysNames[0]] != FieldTypeString {
+		return nil, nil, fmt.Errorf("%w: prefix is set for key '%v' of type %v",
+			ErrFieldType, db.schema.keysNames[0], db.schema.keys[db.schema.keysNames[0]])
+	}
+	if start, err = db.encodeKey(opts.Start); err != nil {
+		return nil, nil, fmt.Errorf("illegal range Start: %w", err)
+	}
+	if end, err = db.encodeKey(opts.End); err != nil {
+		return nil, nil, fmt.Errorf("illegal range End: %w", err)
+	}
+	return start, end, nil
+}
+
+// rangeStart returns key and order of the cursor, which is positioned on the first document of the range.
+// Partial start is completed with the least values of remaining key fields,
+// GT and LTE are replaced with GTE and LT of the next prefix, so documents with start prefix are skipped or included.
+// empty is true if there is no next prefix for GT, so the range is empty.
+// Least values are unknown for Comparator, then key is nil and the cursor starts from the first document.
+func (db *Database) rangeStart(start [][]byte, order Order) (key [][]byte, seek Order, empty bool) {
+	if len(start) == 0 || len(start) == len(db.schema.keysNames) {
+		return start, order, false
+	}
+	if db.comparator != nil {
+		if order == GT || order == GTE {
+			return nil, GTE, false
+		}
+		return nil, LTE, false
+	}
+	switch order {
+	case GT, LTE:
+		next, ok := db.nextPrefix(start)
+		if !ok {
+			// there are no keys after start prefix
+			return nil, order, order == GT
+		}
+		start = next
+		if order == GT {
+			order = GTE
+		} else {
+			order = LT
+		}
+	}
+	key = append(make([][]byte, 0, len(db.schema.keysNames)), start...)
+	for _, name := range db.schema.keysNames[len(start):] {
+		key = append(key, leastValue(db.schema.keys[name]))
+	}
+	return key, order, false
+}
+
+// nextPrefix returns the least key prefix, which is greater than prefix and all keys starting with it
+func (db *Database) nextPrefix(prefix [][]byte) ([][]byte, bool) {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		typ := db.schema.keys[db.schema.keysNames[i]]
+		value := getUint(prefix[i])
+		switch {
+		case typ == FieldTypeString:
+			if next, ok := nextString(prefix[i]); ok {
+				return append(prefix[:i:i], next), true
+			}
+		case typ != typ.direct() && value > 0:
+			return append(prefix[:i:i], putUint(typ.size(), value-1)), true
+		case typ == typ.direct() && value < maxUint(typ.size()):
+			return append(prefix[:i:i], putUint(typ.size(), value+1)), true
+		}
+		// value is the greatest one, next prefix is shorter
+	}
+	return nil, false
+}
+
+// nextString returns the least key string, which is greater than s
+func nextString(s []byte) ([]byte, bool) {
+	// strings are compared bytewise and shorter string is less
+	if len(s) < maxKeyStringSize {
+		return append(append(make([]byte, 0, len(s)+1), s...), 0), true
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] != math.MaxUint8 {
+			return append(append(make([]byte, 0, i+1), s[:i]...), s[i]+1), true
+		}
+	}
+	return nil, false
+}
+
+// leastValue returns the least value of key field in database order
+func leastValue(typ FieldType) []byte {
+	switch {
+	case typ == FieldTypeString:
+		return []byte{}
+	case typ != typ.direct():
+		return putUint(typ.size(), maxUint(typ.size()))
+	}
+	return putUint(typ.size(), 0)
+}
+
+func maxUint(size int) uint64 {
+	return ^uint64(0) >> (64 - 8*size)
+}
+
+// beforeStart checks if document precedes partial start of the range in iteration order
+func (db *Database) beforeStart(d Document, start [][]byte, order Order) bool {
+	c := db.compareKey(d, start)
+	switch order {
+	case GT:
+		return c <= 0
+	case GTE:
+		return c < 0
+	case LT:
+		return c >= 0
+	}
+	return c > 0
+}
+
+// rangeDocument creates a document for the range cursor
+func (db *Database) rangeDocument(prefix []byte, start [][]byte, order Order) (Document, error) {
+	doc := db.Document()
+	if doc.IsEmpty() {
+		return doc, errors.New("failed to create document")
+	}
+	ok := doc.SetString(CursorOrder, string(order))
+	if ok && len(prefix) != 0 {
+		ok = doc.varStore.SetBytes(CursorPrefix, prefix)
+	}
+	for i := 0; ok && i < len(start); i++ {
+		name := db.schema.keysNames[i]
+		if db.schema.keys[name] == FieldTypeString {
+			ok = doc.varStore.SetBytes(name, start[i])
+		} else {
+			ok = doc.SetInt(name, int64(getUint(start[i])))
+		}
+	}
+	if !ok {
+		doc.Free()
+		return Document{}, fmt.Errorf("failed to set range: %v", db.env.Error())
+	}
+	return doc, nil
+}
+
+// encodeKey converts key values to representation of schema key fields
+func (db *Database) encodeKey(key Key) ([][]byte, error) {
+	if len(key) > len(db.schema.keysNames) {
+		return nil, fmt.Errorf("key has %v values, schema has %v key fields", len(key), len(db.schema.keysNames))
+	}
+	if len(key) == 0 {
+		return nil, nil
+	}
+	res := make([][]byte, len(key))
+	for i, value := range key {
+		name := db.schema.keysNames[i]
+		b, err := encodeValue(db.schema.keys[name], value)
+		if err != nil {
+			return nil, fmt.Errorf("field '%v': %w", name, err)
+		}
+		res[i] = b
+	}
+	return res, nil
+}
+
+func encodeValue(typ FieldType, value interface{}) ([]byte, error) {
+	if typ == FieldTypeString {
+		switch v := value.(type) {
+		case string:
+			return []byte(v), nil
+		case []byte:
+			return v, nil
+		}
+		return nil, fmt.Errorf("%w: %T can't be used as %v", ErrFieldType, value, typ)
+	}
+	var u uint64
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Uint, reflect.Uint64, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		u = val.Uint()
+	case reflect.Int, reflect.Int64, reflect.Int8, reflect.Int16, reflect.Int32:
+		if val.Int() < 0 {
+			return nil, fmt.Errorf("%w: negative value %v can't be used as %v", ErrFieldType, val.Int(), typ)
+		}
+		u = uint64(val.Int())
+	default:
+		return nil, fmt.Errorf("%w: %T can't be used as %v", ErrFieldType, value, typ)
+	}
+	size := typ.size()
+	if size < 8 && u>>(8*size) != 0 {
+		return nil, fmt.Errorf("%w: value %v overflows %v", ErrFieldType, u, typ)
+	}
+	return putUint(size, u), nil
+}
+
+// compareKey compares key fields of document with key in database order
+func (db *Database) compareKey(d Document, key [][]byte) int {
+	for i, value := range key {
+		name := db.schema.keysNames[i]
+		var size int
+		ptr := d.Get(name, &size)
+		field := unsafe.Slice((*byte)(ptr), size)
+		if c := db.compareField(db.schema.keys[name], field, value); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (db *Database) compareField(typ FieldType, a, b []byte) int {
+	if db.comparator != nil {
+		return db.comparator(a, b)
+	}
+	c := compareValues(typ.direct(), a, b)
+	if typ != typ.direct() {
+		return -c
+	}
+	return c
+}
@@ -0,0 +1,201 @@
+package sophia
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseRange(t *testing.T) {
+	const recordsCount = 100
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey("group", FieldTypeString))
+	require.Nil(t, schema.AddKey("id", FieldTypeUInt32))
+	require.Nil(t, schema.AddValue("value", FieldTypeString))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	for _, group := range []string{"a", "b", "c"} {
+		for i := 0; i < recordsCount; i++ {
+			doc := db.Document()
+			require.Nil(t, doc.SetBytes("group", []byte(group)))
+			require.Nil(t, doc.SetUint32("id", uint32(i)))
+			require.Nil(t, doc.SetBytes("value", []byte(fmt.Sprint(group, i))))
+			require.Nil(t, db.Set(doc))
+			doc.Free()
+		}
+	}
+
+	values := func(opts RangeOptions) []string {
+		var res []string
+		for d, err := range db.Range(opts) {
+			require.Nil(t, err)
+			value, err := d.GetBytes("value")
+			require.Nil(t, err)
+			res = append(res, string(value))
+		}
+		return res
+	}
+
+	require.Equal(t, []string{"b10", "b11", "b12"},
+		values(RangeOptions{Start: Key{"b", 10}, End: Key{"b", uint32(13)}}))
+	require.Equal(t, []string{"b11", "b12"},
+		values(RangeOptions{Start: Key{"b", 10}, End: Key{"b", 13}, Order: GT}))
+	require.Equal(t, []string{"b13", "b12", "b11"},
+		values(RangeOptions{Start: Key{"b", 13}, End: Key{"b", 10}, Reverse: true}))
+	require.Equal(t, []string{"b98", "b99", "c0"},
+		values(RangeOptions{Start: Key{"b", 98}, Limit: 3}))
+	require.Equal(t, []string{"b99", "b98"},
+		values(RangeOptions{Start: Key{"b"}, Reverse: true, Limit: 2}))
+	require.Len(t, values(RangeOptions{End: Key{"b"}, Reverse: true}), recordsCount)
+	require.Equal(t, []string{"c0", "c1"},
+		values(RangeOptions{Prefix: []byte("c"), Limit: 2}))
+	require.Len(t, values(RangeOptions{Start: Key{"b"}, End: Key{"c"}}), recordsCount)
+	require.Len(t, values(RangeOptions{}), 3*recordsCount)
+
+	for _, opts := range []RangeOptions{
+		{Limit: -1},
+		{Order: LT},
+		{Order: GTE, Reverse: true},
+		{Order: "<>"},
+		{Start: Key{1}},
+		{Start: Key{"a", "b"}},
+		{Start: Key{"a", -1}},
+		{Start: Key{"a", uint64(1 << 32)}},
+		{End: Key{"a", 1, 2}},
+	} {
+		count := 0
+		for d, err := range db.Range(opts) {
+			require.Error(t, err, "%+v", opts)
+			require.True(t, d.IsEmpty())
+			count++
+		}
+		require.Equal(t, 1, count)
+	}
+
+	// break closes cursor, so the database is accessible for writes
+	for range db.Range(RangeOptions{}) {
+		break
+	}
+	doc := db.Document()
+	require.Nil(t, doc.SetBytes("group", []byte("d")))
+	require.Nil(t, doc.SetUint32("id", 0))
+	require.Nil(t, db.Set(doc))
+	doc.Free()
+
+	// cancellation is checked between documents
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count := 0
+	for d, err := range db.RangeContext(ctx, RangeOptions{}) {
+		if err != nil {
+			require.True(t, errors.Is(err, context.Canceled))
+			require.True(t, d.IsEmpty())
+			break
+		}
+		count++
+		if count == 10 {
+			cancel()
+		}
+	}
+	require.Equal(t, 10, count)
+}
+
+func TestDatabaseRangePartialStart(t *testing.T) {
+	const recordsCount = 5
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey("group", FieldTypeString))
+	require.Nil(t, schema.AddKey("id", FieldTypeUInt32Rev))
+	require.Nil(t, schema.AddValue("value", FieldTypeString))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+	cmpDB, err := env.NewDatabase(DatabaseConfig{
+		Name:           "test_comparator",
+		Schema:         schema,
+		Comparator:     bytes.Compare,
+		ComparatorName: "bytes",
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	long := strings.Repeat("\xff", maxKeyStringSize)
+	for _, d := range []*Database{db, cmpDB} {
+		for _, group := range []string{"a", "b", long} {
+			for i := 0; i < recordsCount; i++ {
+				doc := d.Document()
+				require.Nil(t, doc.SetBytes("group", []byte(group)))
+				require.Nil(t, doc.SetUint32("id", uint32(i)))
+				require.Nil(t, doc.SetBytes("value", []byte(fmt.Sprint(group[:1], i))))
+				require.Nil(t, d.Set(doc))
+				doc.Free()
+			}
+		}
+	}
+
+	values := func(db *Database, opts RangeOptions) []string {
+		opts.Limit = 2
+		var res []string
+		for d, err := range db.Range(opts) {
+			require.Nil(t, err)
+			value, err := d.GetBytes("value")
+			require.Nil(t, err)
+			res = append(res, string(value))
+		}
+		return res
+	}
+
+	// ids are reversed, so greater ids go first
+	require.Equal(t, []string{"b4", "b3"}, values(db, RangeOptions{Start: Key{"b"}}))
+	require.Equal(t, []string{"\xff4", "\xff3"}, values(db, RangeOptions{Start: Key{"b"}, Order: GT}))
+	require.Equal(t, []string{"b0", "b1"}, values(db, RangeOptions{Start: Key{"b"}, Reverse: true}))
+	require.Equal(t, []string{"a0", "a1"}, values(db, RangeOptions{Start: Key{"b"}, Order: LT, Reverse: true}))
+	require.Equal(t, []string{"\xff0", "\xff1"}, values(db, RangeOptions{Start: Key{long}, Reverse: true}))
+	require.Empty(t, values(db, RangeOptions{Start: Key{long}, Order: GT}))
+
+	// Comparator database: bytes.Compare orders little-endian ids by their first byte
+	require.Equal(t, []string{"b0", "b1"}, values(cmpDB, RangeOptions{Start: Key{"b"}}))
+	require.Equal(t, []string{"\xff0", "\xff1"}, values(cmpDB, RangeOptions{Start: Key{"b"}, Order: GT}))
+	require.Equal(t, []string{"b4", "b3"}, values(cmpDB, RangeOptions{Start: Key{"b"}, Reverse: true}))
+	require.Equal(t, []string{"a4", "a3"}, values(cmpDB, RangeOptions{Start: Key{"b"}, Order: LT, Reverse: true}))
+}
@@ -0,0 +1,220 @@
+package sophia
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	backupSchemeFile  = "scheme"
+	nodeFileExtension = ".db"
+)
+
+// BackupReport is a result of backup verification
+type BackupReport struct {
+	Databases []DatabaseReport
+}
+
+// DatabaseReport describes a database found in backup
+type DatabaseReport struct {
+	// Name of the database
+	Name string
+	// Schema restored from scheme of the database
+	Schema *Schema
+	// Documents is a number of documents in the database
+	Documents int64
+	// Pages is a number of pages which checksums have been verified
+	Pages int
+}
+
+// Restore copies backup created by Environment.Backup from backupDir into targetPath,
+// which should not exist or should be empty.
+// After restore, environment with EnvironmentPath set to targetPath
+// and the same databases can be opened.
+func Restore(backupDir, targetPath string) error {
+	if _, err := backupDatabases(backupDir); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) != 0 {
+		return fmt.Errorf("restore target '%v' is not empty", targetPath)
+	}
+	if err = os.MkdirAll(targetPath, 0755); err != nil {
+		return err
+	}
+	return copyDir(backupDir, targetPath)
+}
+
+// VerifyBackup checks backup created by Environment.Backup.
+// Backup is opened in temporary directory next to backupDir, which contains hard links
+// to database files of backup and copies of other files, so backupDir isn't modified.
+// Scheme of every database is checked, documents are counted
+// and checksums of all pages of the database files are verified.
+func VerifyBackup(backupDir string) (*BackupReport, error) {
+	names, err := backupDatabases(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	// hard links can be created only on the same file system
+	tmpDir, err := ioutil.TempDir(filepath.Dir(filepath.Clean(backupDir)), ".sophia_verify")
+	if err != nil {
+		tmpDir, err = ioutil.TempDir("", "sophia_verify")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "data")
+	// sophia trusts indexes of database files, so their structure is checked before opening
+	for _, name := range names {
+		if _, err = verifyNodeFiles(filepath.Join(backupDir, name), true); err != nil {
+			return nil, err
+		}
+	}
+	if err = linkDir(backupDir, path); err != nil {
+		return nil, err
+	}
+
+	env, err := NewEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	defer env.Close()
+	env.SetString(EnvironmentPath, path)
+	if err = env.SetSchedulerConfig(SchedulerConfig{Manual: true}); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		// scheme is recovered from the database files
+		if !env.SetString("db", name) {
+			return nil, fmt.Errorf("failed to create database: %v", env.Error())
+		}
+	}
+	if err = env.Open(); err != nil {
+		return nil, fmt.Errorf("failed to open backup: %v", err)
+	}
+
+	report := &BackupReport{}
+	for _, name := range names {
+		dbReport, err := env.verifyDatabase(name, filepath.Join(backupDir, name))
+		if err != nil {
+			return nil, err
+		}
+		report.Databases = append(report.Databases, dbReport)
+	}
+	return report, nil
+}
+
+// verifyNodeFiles checks checksums of all database files in directory.
+// Returns number of verified pages.
+func verifyNodeFiles(dir string, compressed bool) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+nodeFileExtension))
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, file := range files {
+		pages, err := verifyNodeFile(file, compressed)
+		total += pages
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// verifyDatabase checks scheme, files and counts documents of the database opened from backup
+func (env *Environment) verifyDatabase(name, dir string) (DatabaseReport, error) {
+	report := DatabaseReport{Name: name}
+	fields := env.scheme(name)
+	schema, err := schemaFromScheme(fields)
+	if err != nil {
+		return report, fmt.Errorf("database '%v' has invalid scheme: %v", name, err)
+	}
+	report.Schema = schema
+
+	// sophia doesn't verify pages on read, so files are checked before reading documents
+	compressed := env.configString(fmt.Sprintf(keyCompression, name)) != CompressionTypeNone.String()
+	if report.Pages, err = verifyNodeFiles(dir, compressed); err != nil {
+		return report, err
+	}
+
+	ptr := env.GetObject(fmt.Sprintf("db.%s", name))
+	if ptr == nil {
+		return report, fmt.Errorf("failed to get database object: %v", env.Error())
+	}
+	db := &Database{
+		dataStore:   newDataStore(ptr, env),
+		name:        name,
+		schema:      schema,
+		fieldsCount: len(fields),
+	}
+	cursor, err := db.Cursor(db.Document())
+	if err != nil {
+		return report, err
+	}
+	for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+		report.Documents++
+	}
+	if err = cursor.Close(); err != nil {
+		return report, err
+	}
+	if err = env.Error(); err != nil {
+		return report, fmt.Errorf("failed to read database '%v': %v", name, err)
+	}
+	return report, nil
+}
+
+// linkDir recreates directory src in dst with hard links to database files
+// and copies of other files. Database files are copied if they can't be linked.
+// Database files aren't modified by opening environment in manual scheduler mode,
+// because there is no compaction.
+func linkDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case filepath.Ext(path) == nodeFileExtension:
+			if os.Link(path, target) == nil {
+				return nil
+			}
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// backupDatabases returns names of databases stored in backup directory
+func backupDatabases(backupDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(backupDir, entry.Name(), backupSchemeFile))
+		if err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no databases found in backup '%v'", backupDir)
+	}
+	return names, nil
+}
@@ -0,0 +1,142 @@
+package sophia
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreAndVerifyBackup(t *testing.T) {
+	const (
+		keyPath      = "key"
+		valuePath    = "value"
+		dbName       = "test_database"
+		recordsCount = 1000
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	newSchema := func() *Schema {
+		schema := &Schema{}
+		require.Nil(t, schema.AddKey(keyPath, FieldTypeUInt32))
+		require.Nil(t, schema.AddValue(valuePath, FieldTypeString))
+		return schema
+	}
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, filepath.Join(tmpDir, "data")))
+	require.True(t, env.SetString(BackupPath, filepath.Join(tmpDir, "backup")))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:                dbName,
+		Schema:              newSchema(),
+		CompactionCacheSize: 1,
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	for i := 0; i < recordsCount; i++ {
+		doc := db.Document()
+		require.Nil(t, doc.SetUint32(keyPath, uint32(i)))
+		require.True(t, doc.SetString(valuePath, fmt.Sprintf("value%v", i)))
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+	}
+	// wait until documents are written to the database files
+	require.Eventually(t, func() bool {
+		return env.GetInt(fmt.Sprintf("db.%v.index.page_count", dbName)) > 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	backupDir := filepath.Join(tmpDir, "result")
+	_, err = env.Backup(context.Background(), backupDir)
+	require.Nil(t, err)
+
+	report, err := VerifyBackup(backupDir)
+	require.Nil(t, err)
+	require.Len(t, report.Databases, 1)
+	require.Equal(t, dbName, report.Databases[0].Name)
+	require.Equal(t, int64(recordsCount), report.Databases[0].Documents)
+	require.True(t, report.Databases[0].Pages > 0)
+	require.Equal(t, newSchema().scheme(), report.Databases[0].Schema.scheme())
+
+	t.Run("Restore", func(t *testing.T) {
+		target := filepath.Join(tmpDir, "restored")
+		require.Nil(t, Restore(backupDir, target))
+		require.NotNil(t, Restore(backupDir, target))
+
+		env, err := NewEnvironment()
+		require.Nil(t, err)
+		require.True(t, env.SetString(EnvironmentPath, target))
+		db, err := env.NewDatabase(DatabaseConfig{
+			Name:   dbName,
+			Schema: newSchema(),
+		})
+		require.Nil(t, err)
+		require.Nil(t, env.Open())
+		defer env.Close()
+
+		doc := db.Document()
+		require.Nil(t, doc.SetUint32(keyPath, recordsCount-1))
+		d, err := db.Get(doc)
+		doc.Free()
+		require.Nil(t, err)
+		var size int
+		require.Equal(t, fmt.Sprintf("value%v", recordsCount-1), d.GetString(valuePath, &size))
+		d.Destroy()
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		corrupted := filepath.Join(tmpDir, "corrupted")
+		require.Nil(t, copyDir(backupDir, corrupted))
+		files, err := filepath.Glob(filepath.Join(corrupted, dbName, "*.db"))
+		require.Nil(t, err)
+		require.NotEmpty(t, files)
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			require.Nil(t, err)
+			// first page of the first node starts at the beginning of file
+			data[nodePageHeaderSize+1] ^= 0xff
+			require.Nil(t, ioutil.WriteFile(file, data, 0644))
+		}
+		_, err = VerifyBackup(corrupted)
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "bad page data crc")
+	})
+
+	t.Run("Corrupted header", func(t *testing.T) {
+		corrupted := filepath.Join(tmpDir, "corrupted_header")
+		require.Nil(t, copyDir(backupDir, corrupted))
+		files, err := filepath.Glob(filepath.Join(corrupted, dbName, "*.db"))
+		require.Nil(t, err)
+		require.NotEmpty(t, files)
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			require.Nil(t, err)
+			// the last index header has valid crc, but too many pages
+			header := data[len(data)-nodeIndexHeaderSize:]
+			binary.LittleEndian.PutUint32(header[31:], 0xffffffff)
+			binary.LittleEndian.PutUint32(header[0:], sophiaCRC(0, header[4:]))
+			require.Nil(t, ioutil.WriteFile(file, data, 0644))
+		}
+		_, err = VerifyBackup(corrupted)
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "bad index header")
+	})
+
+	_, err = VerifyBackup(tmpDir)
+	require.NotNil(t, err)
+}
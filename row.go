@@ -0,0 +1,167 @@
+package sophia
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Row is a set of document fields, which is passed to UpsertHandler and RowReader.
+// Field values of rows received by handler are valid only during the handler call,
+// key fields of these rows can't be set.
+type Row struct {
+	db *Database
+	// fields values in sophia scheme order
+	fields [][]byte
+	// buffers are reused for values set to the row, if they aren't set values are allocated
+	buffers *rowBuffers
+	// keysFixed prohibits setting of key fields, key of upserted document can't be changed
+	keysFixed bool
+}
+
+// IsEmpty returns true for the row of a document which doesn't exist
+func (r Row) IsEmpty() bool {
+	return r.fields == nil
+}
+
+// SetUint8 sets value of u8 or u8rev field
+func (r Row) SetUint8(name string, val uint8) error {
+	b, err := r.setField(name, FieldTypeUInt8, 1)
+	if err != nil {
+		return err
+	}
+	b[0] = val
+	return nil
+}
+
+// SetUint16 sets value of u16 or u16rev field
+func (r Row) SetUint16(name string, val uint16) error {
+	b, err := r.setField(name, FieldTypeUInt16, 2)
+	if err != nil {
+		return err
+	}
+	binary.NativeEndian.PutUint16(b, val)
+	return nil
+}
+
+// SetUint32 sets value of u32 or u32rev field
+func (r Row) SetUint32(name string, val uint32) error {
+	b, err := r.setField(name, FieldTypeUInt32, 4)
+	if err != nil {
+		return err
+	}
+	binary.NativeEndian.PutUint32(b, val)
+	return nil
+}
+
+// SetUint64 sets value of u64 or u64rev field
+func (r Row) SetUint64(name string, val uint64) error {
+	b, err := r.setField(name, FieldTypeUInt64, 8)
+	if err != nil {
+		return err
+	}
+	binary.NativeEndian.PutUint64(b, val)
+	return nil
+}
+
+// SetBytes sets value of string field. Value is copied.
+func (r Row) SetBytes(name string, val []byte) error {
+	b, err := r.setField(name, FieldTypeString, len(val))
+	if err != nil {
+		return err
+	}
+	copy(b, val)
+	return nil
+}
+
+// GetUint8 returns value of u8 or u8rev field
+func (r Row) GetUint8(name string) (uint8, error) {
+	b, err := r.getField(name, FieldTypeUInt8)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// GetUint16 returns value of u16 or u16rev field
+func (r Row) GetUint16(name string) (uint16, error) {
+	b, err := r.getField(name, FieldTypeUInt16)
+	if err != nil {
+		return 0, err
+	}
+	return binary.NativeEndian.Uint16(b), nil
+}
+
+// GetUint32 returns value of u32 or u32rev field
+func (r Row) GetUint32(name string) (uint32, error) {
+	b, err := r.getField(name, FieldTypeUInt32)
+	if err != nil {
+		return 0, err
+	}
+	return binary.NativeEndian.Uint32(b), nil
+}
+
+// GetUint64 returns value of u64 or u64rev field
+func (r Row) GetUint64(name string) (uint64, error) {
+	b, err := r.getField(name, FieldTypeUInt64)
+	if err != nil {
+		return 0, err
+	}
+	return binary.NativeEndian.Uint64(b), nil
+}
+
+// GetBytes returns copy of string field value
+func (r Row) GetBytes(name string) ([]byte, error) {
+	b, err := r.getField(name, FieldTypeString)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, b...), nil
+}
+
+// setField returns a slice of given size, which is set as value of the field
+func (r Row) setField(name string, typ FieldType, size int) ([]byte, error) {
+	pos, err := r.position(name, typ)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := r.db.schema.keys[name]; ok && r.keysFixed {
+		return nil, fmt.Errorf("%w: '%v'", ErrKeyField, name)
+	}
+	var b []byte
+	if r.buffers != nil {
+		if b, err = r.buffers.get(pos, size); err != nil {
+			return nil, err
+		}
+	} else {
+		b = make([]byte, size)
+	}
+	r.fields[pos] = b
+	return b, nil
+}
+
+func (r Row) getField(name string, typ FieldType) ([]byte, error) {
+	pos, err := r.position(name, typ)
+	if err != nil {
+		return nil, err
+	}
+	b := r.fields[pos]
+	if typ.size() != 0 && len(b) != typ.size() {
+		return nil, fmt.Errorf("%w: field '%v' has size %v, expected %v", ErrFieldType, name, len(b), typ.size())
+	}
+	return b, nil
+}
+
+// position validates field like Document.checkField and returns it's position in row
+func (r Row) position(name string, typ FieldType) (int, error) {
+	if r.IsEmpty() {
+		return 0, fmt.Errorf("%w: row is empty", ErrUnknownField)
+	}
+	fieldType, ok := r.db.schema.fieldType(name)
+	if !ok {
+		return 0, fmt.Errorf("%w: '%v'", ErrUnknownField, name)
+	}
+	if fieldType.direct() != typ {
+		return 0, fmt.Errorf("%w: field '%v' has type %v, not %v", ErrFieldType, name, fieldType, typ)
+	}
+	return r.db.positions[name], nil
+}
@@ -0,0 +1,124 @@
+package sophia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	keySchedulerThreads    = "scheduler.threads"
+	keySchedulerRun        = "scheduler.run"
+	keySchedulerCheckpoint = "scheduler.checkpoint"
+	keyLogRotate           = "log.rotate"
+	keyLogGC               = "log.gc"
+	keyCompactionGC        = "db.%v.compaction.gc"
+	keyCompactionExpire    = "db.%v.compaction.expire"
+)
+
+// SchedulerConfig configures sophia scheduler, which runs compaction,
+// garbage collection, expiration, log rotation and backups.
+type SchedulerConfig struct {
+	// Threads is a number of background scheduler threads.
+	// Zero value means sophia default.
+	Threads int
+	// Manual disables background scheduler threads.
+	// Maintenance is performed only by Compact, Checkpoint, RunGC and RotateLog then.
+	Manual bool
+}
+
+// SetSchedulerConfig configures scheduler.
+// It should be called before Environment's Open().
+func (env *Environment) SetSchedulerConfig(config SchedulerConfig) error {
+	if env.ptr == nil {
+		return ErrEnvironmentClosed
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	threads := int64(config.Threads)
+	if !config.Manual && threads == 0 {
+		return nil
+	}
+	if !env.SetInt(keySchedulerThreads, threads) {
+		return fmt.Errorf("failed to configure scheduler: %v", env.Error())
+	}
+	return nil
+}
+
+func (config SchedulerConfig) validate() error {
+	if config.Threads < 0 {
+		return fmt.Errorf("illegal configuration: negative number of scheduler threads %v", config.Threads)
+	}
+	if config.Manual && config.Threads > 0 {
+		return errors.New("illegal configuration: scheduler threads are set in manual mode")
+	}
+	return nil
+}
+
+// Checkpoint synchronously writes in-memory data of all databases to the database files.
+// Only data written before the call is waited for, so concurrent writers don't delay it.
+func (env *Environment) Checkpoint() error {
+	return env.call(keySchedulerCheckpoint, "checkpoint")
+}
+
+// RotateLog forces switching to a new log file.
+func (env *Environment) RotateLog() error {
+	return env.call(keyLogRotate, "rotate log")
+}
+
+// RunGC schedules garbage collection of all databases and removes log files,
+// which are not needed anymore.
+// In manual scheduler mode garbage collection is run by the next Compact call.
+// It can be called concurrently with NewDatabase.
+func (env *Environment) RunGC() error {
+	if env.ptr == nil {
+		return ErrEnvironmentClosed
+	}
+	for _, db := range env.Databases() {
+		if err := env.call(fmt.Sprintf(keyCompactionGC, db.name), "run gc"); err != nil {
+			return err
+		}
+		if db.expireTTL > 0 {
+			if err := env.call(fmt.Sprintf(keyCompactionExpire, db.name), "run expire"); err != nil {
+				return err
+			}
+		}
+	}
+	return env.call(keyLogGC, "run log gc")
+}
+
+// Compact runs checkpoint and then runs scheduler in the calling goroutine
+// until there is no more pending work or ctx is done.
+// It is intended for running maintenance in low-traffic windows,
+// especially with manual scheduler mode.
+func (env *Environment) Compact(ctx context.Context) error {
+	if err := env.Checkpoint(); err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if env.ptr == nil {
+			return ErrEnvironmentClosed
+		}
+		switch spCall(env.ptr, getCStringFromCache(keySchedulerRun)) {
+		case 0:
+			return nil
+		case -1:
+			return fmt.Errorf("failed to compact: %v", env.Error())
+		}
+	}
+}
+
+// call runs sophia function by its configuration key
+func (env *Environment) call(key, action string) error {
+	if env.ptr == nil {
+		return ErrEnvironmentClosed
+	}
+	if spCall(env.ptr, getCStringFromCache(key)) == -1 {
+		return fmt.Errorf("failed to %v: %v", action, env.Error())
+	}
+	return nil
+}
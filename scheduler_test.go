@@ -0,0 +1,153 @@
+package sophia
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerManualMode(t *testing.T) {
+	const (
+		keyPath      = "key"
+		valuePath    = "value"
+		recordsCount = 100
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+	require.NotNil(t, env.SetSchedulerConfig(SchedulerConfig{Threads: -1}))
+	require.NotNil(t, env.SetSchedulerConfig(SchedulerConfig{Threads: 1, Manual: true}))
+	require.Nil(t, env.SetSchedulerConfig(SchedulerConfig{Manual: true}))
+	require.Equal(t, int64(0), env.GetInt(keySchedulerThreads))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	for i := 0; i < recordsCount; i++ {
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, fmt.Sprintf("key%v", i)))
+		require.True(t, doc.Set(valuePath, fmt.Sprintf("value%v", i)))
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+	}
+
+	stats, err := db.Stats()
+	require.Nil(t, err)
+	require.Equal(t, int64(recordsCount), stats.Documents)
+	size := stats.Size
+
+	require.Nil(t, env.Checkpoint())
+
+	stats, err = db.Stats()
+	require.Nil(t, err)
+	require.Equal(t, int64(0), stats.Documents)
+	require.True(t, stats.Size > size)
+	require.Equal(t, int64(recordsCount), stats.Count)
+
+	envStats, err := env.Stats()
+	require.Nil(t, err)
+	require.Nil(t, env.RotateLog())
+	rotated, err := env.Stats()
+	require.Nil(t, err)
+	require.Equal(t, envStats.LFSN+1, rotated.LFSN)
+
+	require.Nil(t, env.RunGC())
+	require.Nil(t, env.Compact(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, env.Compact(ctx))
+
+	doc := db.Document()
+	require.True(t, doc.Set(keyPath, "key1"))
+	d, err := db.Get(doc)
+	doc.Free()
+	require.Nil(t, err)
+	var valueSize int
+	require.Equal(t, "value1", d.GetString(valuePath, &valueSize))
+	d.Destroy()
+}
+
+func TestSchedulerCheckpoint(t *testing.T) {
+	const (
+		keyPath      = "key"
+		valuePath    = "value"
+		recordsCount = 100
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+	require.Nil(t, env.SetSchedulerConfig(SchedulerConfig{Manual: true}))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	set := func(i int) {
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, fmt.Sprintf("key%v", i)))
+		require.True(t, doc.Set(valuePath, fmt.Sprintf("value%v", i)))
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+	}
+	for i := 0; i < recordsCount; i++ {
+		set(i)
+	}
+
+	// scheduled work other than checkpoint doesn't flush in-memory data below cache watermark
+	require.Nil(t, env.RunGC())
+	for spCall(env.ptr, getCStringFromCache(keySchedulerRun)) > 0 {
+	}
+	stats, err := db.Stats()
+	require.Nil(t, err)
+	require.Equal(t, int64(recordsCount), stats.Documents)
+
+	// checkpoint isn't delayed by concurrent writes
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := recordsCount; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				set(i)
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.Nil(t, env.Checkpoint())
+	close(stop)
+	wg.Wait()
+}
@@ -1,6 +1,14 @@
 package sophia
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSchemaFields maximum number of schema fields, sophia adds two meta fields to them
+const maxSchemaFields = 62
 
 // Schema is a structure for configuring fields which record will contain
 type Schema struct {
@@ -10,6 +18,8 @@ type Schema struct {
 	// name -> type
 	values      map[string]FieldType
 	valuesNames []string
+	// timestamp name of value field, that is used by sophia for documents expiration
+	timestamp string
 }
 
 // AddKey adds new key field for record.
@@ -40,9 +50,136 @@ func (s *Schema) AddValue(name string, typ FieldType) error {
 	return nil
 }
 
+// schemeField is a description of field in terms of sophia scheme
+type schemeField struct {
+	name    string
+	options string
+}
+
+func (f schemeField) String() string {
+	return fmt.Sprintf("'%v' (%v)", f.name, f.options)
+}
+
+// scheme returns fields in the order they should be registered in sophia:
+// keys first in declaration order, then values in declaration order.
+// Position of key in keysNames defines it's index in compound key.
+func (s *Schema) scheme() []schemeField {
+	fields := make([]schemeField, 0, len(s.keysNames)+len(s.valuesNames))
+	for i, name := range s.keysNames {
+		fields = append(fields, schemeField{
+			name:    name,
+			options: fmt.Sprintf("%s,key(%d)", s.keys[name].String(), i),
+		})
+	}
+	for _, name := range s.valuesNames {
+		options := s.values[name].String()
+		if name == s.timestamp {
+			options += ",timestamp,expire"
+		}
+		fields = append(fields, schemeField{
+			name:    name,
+			options: options,
+		})
+	}
+	return fields
+}
+
+// withTimestamp returns copy of schema with additional value field,
+// which sophia fills with document creation time and uses for expiration.
+func (s *Schema) withTimestamp(name string) (*Schema, error) {
+	ret := &Schema{
+		keysNames:   append([]string(nil), s.keysNames...),
+		valuesNames: append([]string(nil), s.valuesNames...),
+		keys:        make(map[string]FieldType, len(s.keys)),
+		values:      make(map[string]FieldType, len(s.values)+1),
+	}
+	for n, typ := range s.keys {
+		ret.keys[n] = typ
+	}
+	for n, typ := range s.values {
+		ret.values[n] = typ
+	}
+	if _, ok := ret.keys[name]; ok {
+		return nil, fmt.Errorf("duplicate field, '%v' is reserved for expiration timestamp", name)
+	}
+	if err := ret.AddValue(name, FieldTypeUInt32); err != nil {
+		return nil, err
+	}
+	ret.timestamp = name
+	return ret, nil
+}
+
+// fieldType returns type of key or value field with given name
+func (s *Schema) fieldType(name string) (FieldType, bool) {
+	if typ, ok := s.keys[name]; ok {
+		return typ, true
+	}
+	typ, ok := s.values[name]
+	return typ, ok
+}
+
+// positions returns positions of fields in sophia scheme by their names
+func (s *Schema) positions() map[string]int {
+	positions := make(map[string]int, len(s.keysNames)+len(s.valuesNames))
+	for i, field := range s.scheme() {
+		positions[field.name] = i
+	}
+	return positions
+}
+
 func defaultSchema() *Schema {
 	schema := &Schema{}
 	schema.AddKey("key", FieldTypeString)
 	schema.AddValue("value", FieldTypeString)
 	return schema
 }
+
+// schemaFromScheme restores Schema from fields of sophia scheme
+func schemaFromScheme(fields []schemeField) (*Schema, error) {
+	type key struct {
+		name  string
+		typ   FieldType
+		index int
+	}
+	var (
+		keys   []key
+		schema = &Schema{}
+	)
+	for _, field := range fields {
+		options := strings.Split(field.options, ",")
+		typ, ok := fieldTypeByName(options[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown type of field %v", field)
+		}
+		index := -1
+		for _, option := range options[1:] {
+			switch {
+			case strings.HasPrefix(option, "key(") && strings.HasSuffix(option, ")"):
+				i, err := strconv.Atoi(option[len("key(") : len(option)-1])
+				if err != nil {
+					return nil, fmt.Errorf("malformed key option of field %v", field)
+				}
+				index = i
+			case option == "timestamp":
+				schema.timestamp = field.name
+			}
+		}
+		if index >= 0 {
+			keys = append(keys, key{name: field.name, typ: typ, index: index})
+			continue
+		}
+		if err := schema.AddValue(field.name, typ); err != nil {
+			return nil, err
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("scheme has no key fields")
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].index < keys[j].index })
+	for _, k := range keys {
+		if err := schema.AddKey(k.name, k.typ); err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
@@ -57,3 +57,18 @@ func TestSchemaDupValue(t *testing.T) {
 	require.Equal(t, FieldTypeString, schema.values[valueName])
 	require.Equal(t, valueName, schema.valuesNames[0])
 }
+
+func TestSchemaSchemeOrder(t *testing.T) {
+	schema := Schema{}
+	require.Nil(t, schema.AddValue("value2", FieldTypeString))
+	require.Nil(t, schema.AddKey("key2", FieldTypeUInt64Rev))
+	require.Nil(t, schema.AddValue("value1", FieldTypeUInt32))
+	require.Nil(t, schema.AddKey("key1", FieldTypeString))
+
+	require.Equal(t, []schemeField{
+		{name: "key2", options: "u64_rev,key(0)"},
+		{name: "key1", options: "string,key(1)"},
+		{name: "value2", options: "string"},
+		{name: "value1", options: "u32"},
+	}, schema.scheme())
+}
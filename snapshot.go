@@ -0,0 +1,119 @@
+package sophia
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrSnapshotClosed is returned on usage of closed Snapshot
+var ErrSnapshotClosed = errors.New("usage of closed Snapshot")
+
+const keyTxVLSN = "vlsn"
+
+// Snapshot is a read-only point-in-time view of all databases of the environment.
+// Reads from a snapshot see the state at the moment the snapshot was created,
+// concurrent writes aren't visible for it.
+// Close() should be called to release the snapshot, versions needed by it
+// are kept by sophia until then.
+// Snapshot is not safe for concurrent use.
+type Snapshot struct {
+	tx   *Transaction
+	env  *Environment
+	name string
+	lsn  int64
+}
+
+// namedSnapshots keeps named snapshots of an environment
+type namedSnapshots struct {
+	mu    sync.Mutex
+	named map[string]*Snapshot
+}
+
+// Snapshot creates a new unnamed Snapshot of the environment.
+func (env *Environment) Snapshot() (*Snapshot, error) {
+	tx, err := env.BeginTx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+	}
+	return &Snapshot{
+		tx:  tx,
+		env: env,
+		lsn: spGetInt(tx.ptr, getCStringFromCache(keyTxVLSN)),
+	}, nil
+}
+
+// NamedSnapshot returns named Snapshot of the environment.
+// It is created on the first call, next calls return the same Snapshot until it is closed,
+// so callers sharing it should synchronize it's usage.
+// Names are local to the process: they aren't sophia views and don't survive Close() of the environment.
+func (env *Environment) NamedSnapshot(name string) (*Snapshot, error) {
+	env.snapshots.mu.Lock()
+	defer env.snapshots.mu.Unlock()
+	if snapshot, ok := env.snapshots.named[name]; ok {
+		return snapshot, nil
+	}
+	snapshot, err := env.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.name = name
+	if env.snapshots.named == nil {
+		env.snapshots.named = make(map[string]*Snapshot)
+	}
+	env.snapshots.named[name] = snapshot
+	return snapshot, nil
+}
+
+// closeNamedSnapshots releases all named snapshots
+func (env *Environment) closeNamedSnapshots() {
+	env.snapshots.mu.Lock()
+	named := env.snapshots.named
+	env.snapshots.named = nil
+	env.snapshots.mu.Unlock()
+	for _, snapshot := range named {
+		snapshot.name = ""
+		snapshot.Close()
+	}
+}
+
+// Name returns name of the Snapshot, it is empty for unnamed snapshots
+func (s *Snapshot) Name() string {
+	return s.name
+}
+
+// LSN returns log sequence number the Snapshot is pinned to
+func (s *Snapshot) LSN() int64 {
+	return s.lsn
+}
+
+// Get retrieves the row for the set of keys as it was at the moment of snapshot creation.
+func (s *Snapshot) Get(doc Document) (Document, error) {
+	if s.tx.finished {
+		return Document{}, ErrSnapshotClosed
+	}
+	return s.tx.Get(doc)
+}
+
+// Cursor returns a Cursor for iterating over rows of the database
+// as they were at the moment of snapshot creation.
+// Cursor should be closed, Close() of the Snapshot closes it if it is still open.
+func (s *Snapshot) Cursor(doc Document) (*Cursor, error) {
+	if s.tx.finished {
+		return nil, ErrSnapshotClosed
+	}
+	return s.tx.Cursor(doc.db, doc)
+}
+
+// Close releases the Snapshot.
+func (s *Snapshot) Close() error {
+	if s.tx.finished {
+		return ErrSnapshotClosed
+	}
+	if s.name != "" {
+		s.env.snapshots.mu.Lock()
+		delete(s.env.snapshots.named, s.name)
+		s.env.snapshots.mu.Unlock()
+	}
+	return s.tx.Rollback()
+}
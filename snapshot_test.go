@@ -0,0 +1,185 @@
+package sophia
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	const (
+		keyPath      = "key"
+		valuePath    = "value"
+		recordsCount = 10
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(keyPath, FieldTypeUInt64))
+	require.Nil(t, schema.AddValue(valuePath, FieldTypeUInt64))
+
+	db1, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database1",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+	db2, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database2",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	set := func(db *Database, key, value int64) {
+		doc := db.Document()
+		require.True(t, doc.SetInt(keyPath, key))
+		require.True(t, doc.SetInt(valuePath, value))
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+	}
+	get := func(s *Snapshot, db *Database, key int64) int64 {
+		doc := db.Document()
+		require.True(t, doc.SetInt(keyPath, key))
+		d, err := s.Get(doc)
+		doc.Free()
+		require.Nil(t, err)
+		defer d.Destroy()
+		return d.GetInt(valuePath)
+	}
+	count := func(s *Snapshot, db *Database) int {
+		cursor, err := s.Cursor(db.Document())
+		require.Nil(t, err)
+		defer cursor.Close()
+		var n int
+		for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+			require.Equal(t, d.GetInt(keyPath), d.GetInt(valuePath))
+			n++
+		}
+		return n
+	}
+
+	for i := int64(0); i < recordsCount; i++ {
+		set(db1, i, i)
+		set(db2, i, i)
+	}
+
+	snapshot, err := env.Snapshot()
+	require.Nil(t, err)
+	require.Empty(t, snapshot.Name())
+	require.True(t, snapshot.LSN() > 0)
+
+	named, err := env.NamedSnapshot("report")
+	require.Nil(t, err)
+	require.Equal(t, "report", named.Name())
+	sameNamed, err := env.NamedSnapshot("report")
+	require.Nil(t, err)
+	require.True(t, named == sameNamed)
+
+	// writers keep going
+	for i := int64(0); i < recordsCount; i++ {
+		set(db1, i, i+recordsCount)
+		set(db2, i+recordsCount, i+recordsCount)
+	}
+
+	for _, s := range []*Snapshot{snapshot, named} {
+		require.Equal(t, int64(1), get(s, db1, 1))
+		require.Equal(t, recordsCount, count(s, db1))
+		require.Equal(t, recordsCount, count(s, db2))
+
+		doc := db2.Document()
+		require.True(t, doc.SetInt(keyPath, recordsCount))
+		_, err = s.Get(doc)
+		doc.Free()
+		require.Equal(t, ErrNotFound, err)
+	}
+
+	// closed cursors are forgotten, open ones are closed by Close
+	require.Empty(t, snapshot.tx.cursors)
+	cursor, err := snapshot.Cursor(db1.Document())
+	require.Nil(t, err)
+	d := cursor.Next()
+	require.False(t, d.IsEmpty())
+
+	require.Nil(t, snapshot.Close())
+	d = cursor.Next()
+	require.True(t, d.IsEmpty())
+	require.Equal(t, ErrCursorClosed, cursor.Close())
+	require.Equal(t, ErrSnapshotClosed, snapshot.Close())
+	_, err = snapshot.Get(db1.Document())
+	require.Equal(t, ErrSnapshotClosed, err)
+	_, err = snapshot.Cursor(db1.Document())
+	require.Equal(t, ErrSnapshotClosed, err)
+
+	require.Nil(t, named.Close())
+	named, err = env.NamedSnapshot("report")
+	require.Nil(t, err)
+	require.False(t, named == sameNamed)
+	require.Equal(t, int64(recordsCount+1), get(named, db1, 1))
+	require.Equal(t, 2*recordsCount, count(named, db2))
+}
+
+func TestSnapshotGetVersion(t *testing.T) {
+	const (
+		keyPath       = "key"
+		valuePath     = "value"
+		versionsCount = 5
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(keyPath, FieldTypeUInt64))
+	require.Nil(t, schema.AddValue(valuePath, FieldTypeUInt64))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	// every snapshot sees the version of in-memory document
+	// written before it, not the latest one
+	snapshots := make([]*Snapshot, versionsCount)
+	for i := range snapshots {
+		doc := db.Document()
+		require.True(t, doc.SetInt(keyPath, 1))
+		require.True(t, doc.SetInt(valuePath, int64(i)))
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+		snapshots[i], err = env.Snapshot()
+		require.Nil(t, err)
+		defer snapshots[i].Close()
+	}
+
+	for i, s := range snapshots {
+		doc := db.Document()
+		require.True(t, doc.SetInt(keyPath, 1))
+		d, err := s.Get(doc)
+		doc.Free()
+		require.Nil(t, err)
+		require.Equal(t, int64(i), d.GetInt(valuePath))
+		d.Destroy()
+	}
+}
@@ -13941,7 +13941,7 @@ sf_compare(sfscheme *s, char *a, char *b)
 		char *a_field = sf_fieldptr(s, key, a, &a_fieldsize);
 		uint32_t b_fieldsize;
 		char *b_field = sf_fieldptr(s, key, b, &b_fieldsize);
-		rc = key->cmp(a_field, a_fieldsize, b_field, b_fieldsize, NULL);
+		rc = key->cmp(a_field, a_fieldsize, b_field, b_fieldsize, s->cmparg);
 		if (rc != 0)
 			return rc;
 		part++;
@@ -14147,10 +14147,6 @@ sf_schemevalidate(sfscheme *s, ssa *a)
 		if (f->options == NULL) {
 			return -1;
 		}
-		/* set user compare function */
-		if (s->cmp) {
-			f->cmp = s->cmp;
-		}
 		char opts[256];
 		snprintf(opts, sizeof(opts), "%s", f->options);
 		char *p;
@@ -14161,6 +14157,10 @@ sf_schemevalidate(sfscheme *s, ssa *a)
 			if (ssunlikely(rc == -1))
 				return -1;
 		}
+		/* set user compare function */
+		if (s->cmp) {
+			f->cmp = s->cmp;
+		}
 		/* validate auto modifiers */
 		if (f->timestamp) {
 			if (f->type != SS_U32)
@@ -14493,6 +14493,11 @@ struct srerror {
 	srlog *log;
 };
 
+/* number of errors set by the current thread, it allows
+ * to check whether a call has failed without races with
+ * errors of other threads */
+static __thread uint64_t sr_errors_thread;
+
 static inline void
 sr_errorinit(srerror *e, srlog *log)
 {
@@ -14558,6 +14563,7 @@ sr_verrorset(srerror *e, int type,
 {
 	ss_spinlock(&e->lock);
 	e->errors++;
+	sr_errors_thread++;
 	if (ssunlikely(e->type == SR_ERROR_MALFUNCTION)) {
 		ss_spinunlock(&e->lock);
 		return;
@@ -16083,17 +16089,17 @@ sv_upsertpop(svupsert *u)
 static inline int
 sv_upsertdo(svupsert *u, sr *r, svupsertnode *a, svupsertnode *b)
 {
-	assert(r->scheme->fields_count <= 16);
+	int count = r->scheme->fields_count;
 
-	uint32_t  src_size[16];
-	char     *src[16];
+	uint32_t  src_size[count];
+	char     *src[count];
 	void     *src_ptr;
 	uint32_t *src_size_ptr;
 
-	uint32_t  upsert_size[16];
-	char     *upsert[16];
-	uint32_t  result_size[16];
-	char     *result[16];
+	uint32_t  upsert_size[count];
+	char     *upsert[count];
+	uint32_t  result_size[count];
+	char     *result[count];
 
 	int i = 0;
 	if (sslikely(a && !(sf_flags(r->scheme, a->buf.s) & SVDELETE) ))
@@ -16126,11 +16132,25 @@ sv_upsertdo(svupsert *u, sr *r, svupsertnode *a, svupsertnode *b)
 	                         result,
 	                         result_size,
 	                         r->upsert->arg);
-	if (ssunlikely(rc == -1))
-		return -1;
+	int flags = sf_flags(r->scheme, b->buf.s) & ~SVUPSERT;
+	if (ssunlikely(rc == -1)) {
+		/* discard failed upsert and keep previous version,
+		 * because readers and compaction can't skip a statement.
+		 * if there is no previous version, upsert is replaced
+		 * by delete statement with the same key */
+		for (i = 0; i < count; i++) {
+			char *prev = src_ptr ? src[i] : upsert[i];
+			if (result[i] != prev)
+				free(result[i]);
+			result[i] = prev;
+			result_size[i] = src_ptr ? src_size[i] : upsert_size[i];
+		}
+		if (src_ptr == NULL)
+			flags |= SVDELETE;
+	}
 
 	/* validate and create new record */
-	sfv v[16];
+	sfv v[count];
 	i = 0;
 	for ( ; i < r->scheme->fields_count; i++) {
 		v[i].pointer = result[i];
@@ -16144,8 +16164,7 @@ sv_upsertdo(svupsert *u, sr *r, svupsertnode *a, svupsertnode *b)
 	sf_write(r->scheme, v, u->tmp.s);
 	ss_bufadvance(&u->tmp, size);
 	/* update meta-fields */
-	sf_flagsset(r->scheme, u->tmp.s,
-	            sf_flags(r->scheme, b->buf.s) & ~SVUPSERT);
+	sf_flagsset(r->scheme, u->tmp.s, flags);
 
 	/* save result */
 	rc = sv_upsertpush(u, r, u->tmp.s);
@@ -16688,6 +16707,7 @@ sv_readiter_next(ssiter *i)
 		ss_iternext(sv_mergeiter, im->merge);
 	im->next = 0;
 	im->v = NULL;
+again:
 	for (; ss_iterhas(sv_mergeiter, im->merge); ss_iternext(sv_mergeiter, im->merge))
 	{
 		char *v = ss_iterof(sv_mergeiter, im->merge);
@@ -16708,6 +16728,13 @@ sv_readiter_next(ssiter *i)
 			int rc = sv_readiter_upsert(im);
 			if (ssunlikely(rc == -1))
 				return;
+			/* failed upsert of missing document, merge iterator
+			 * is already positioned on the next key */
+			if (ssunlikely(!im->save_delete &&
+			               sf_is(im->r->scheme, im->u->result, SVDELETE))) {
+				im->nextdup = 0;
+				goto again;
+			}
 			im->v = im->u->result;
 			im->next = 0;
 		} else {
@@ -22070,10 +22097,13 @@ struct siplanner {
 #define SI_NODEGC     8
 #define SI_BACKUP     16
 #define SI_BACKUPEND  32
+#define SI_CHECKPOINT 64
 
 struct siplan {
 	int plan;
 	/* compaction:
+	 * checkpoint:
+	 *   a: lsn
 	 * gc:
 	 *   a: lsn
 	 *   b: percent
@@ -22959,6 +22989,7 @@ si_execute(si *i, sdc *c, siplan *plan, uint64_t vlsn)
 	int rc = -1;
 	switch (plan->plan) {
 	case SI_COMPACTION:
+	case SI_CHECKPOINT:
 	case SI_GC:
 	case SI_EXPIRE:
 		rc = si_compaction(i, c, plan, vlsn);
@@ -23854,6 +23885,8 @@ int si_plannertrace(siplan *p, uint32_t id, sstrace *t)
 	switch (p->plan) {
 	case SI_COMPACTION: plan = "compaction";
 		break;
+	case SI_CHECKPOINT: plan = "checkpoint";
+		break;
 	case SI_GC: plan = "gc";
 		break;
 	case SI_EXPIRE: plan = "expire";
@@ -23949,6 +23982,31 @@ match:
 	return SI_PMATCH;
 }
 
+static inline siplannerrc
+si_plannerpeek_checkpoint(siplanner *p, siplan *plan)
+{
+	/* try to peek a node which has in-memory data
+	 * with lsn <= required value */
+	siplannerrc rc = SI_PNONE;
+	sinode *n;
+	ssrqnode *pn = NULL;
+	while ((pn = ss_rqprev(&p->memory, pn))) {
+		n = sscast(pn, sinode, nodememory);
+		if (n->i0.lsnmin <= plan->a) {
+			if (n->flags & SI_LOCK) {
+				rc = SI_PRETRY;
+				continue;
+			}
+			goto match;
+		}
+	}
+	return rc;
+match:
+	si_nodelock(n);
+	plan->node = n;
+	return SI_PMATCH;
+}
+
 static inline siplannerrc
 si_plannerpeek_gc(siplanner *p, siplan *plan)
 {
@@ -24034,6 +24092,8 @@ si_planner(siplanner *p, siplan *plan)
 	switch (plan->plan) {
 	case SI_COMPACTION:
 		return si_plannerpeek_memory(p, plan);
+	case SI_CHECKPOINT:
+		return si_plannerpeek_checkpoint(p, plan);
 	case SI_NODEGC:
 		return si_plannerpeek_nodegc(p, plan);
 	case SI_GC:
@@ -24241,6 +24301,8 @@ result:;
 		visible = sv_vvisible(visible, q->r, q->vlsn);
 		if (visible == NULL)
 			return 0;
+		/* return the visible version, not the latest one */
+		v = sv_vpointer(visible);
 	}
 	return si_getresult(q, v, 0);
 }
@@ -25164,8 +25226,13 @@ int si_schemerecover(sischeme *s, sr *r)
 			break;
 		}
 		case SI_SCHEME_SCHEME: {
+			/* keep user compare function */
+			sfcmpf cmp = s->scheme.cmp;
+			void *cmparg = s->scheme.cmparg;
 			sf_schemefree(&s->scheme, r->a);
 			sf_schemeinit(&s->scheme);
+			sf_schemeset_comparator(&s->scheme, cmp);
+			sf_schemeset_comparatorarg(&s->scheme, cmparg);
 			ssbuf buf;
 			ss_bufinit(&buf);
 			rc = sf_schemeload(&s->scheme, r->a, sd_schemesz(opt), opt->size);
@@ -25824,6 +25891,7 @@ int sc_backupstop(sc*);
 
 int sc_ctl_call(sc*, uint64_t);
 int sc_ctl_compaction(sc*, uint64_t, si*);
+int sc_ctl_checkpoint(sc*, uint64_t);
 int sc_ctl_expire(sc*, si*);
 int sc_ctl_gc(sc*, si*);
 int sc_ctl_backup(sc*);
@@ -26190,6 +26258,48 @@ int sc_ctl_compaction(sc *s, uint64_t vlsn, si *index)
 	return rc;
 }
 
+int sc_ctl_checkpoint(sc *s, uint64_t vlsn)
+{
+	sr *r = s->r;
+	int rc = sr_statusactive(r->status);
+	if (ssunlikely(rc == 0))
+		return 0;
+	scworker *w = sc_workerpool_pop(&s->wp, r);
+	if (ssunlikely(w == NULL))
+		return -1;
+	/* data written after checkpoint start is not
+	 * waited for, so concurrent writers can't delay it */
+	uint64_t lsn = sr_seq(r->seq, SR_LSN);
+	int i = 0;
+	while (i < s->count) {
+		si *index = s->i[i].index;
+		/* compact in-memory data of every node */
+		for (;;) {
+			siplan plan = {
+				.plan = SI_CHECKPOINT,
+				.a    = lsn,
+				.node = NULL
+			};
+			rc = si_plan(index, &plan);
+			if (rc == SI_PRETRY) {
+				/* node is being compacted by scheduler thread */
+				ss_sleep(1000000); /* 1ms */
+				continue;
+			}
+			if (rc != SI_PMATCH)
+				break;
+			rc = si_execute(index, &w->dc, &plan, vlsn);
+			if (ssunlikely(rc == -1))
+				goto done;
+		}
+		i++;
+	}
+	rc = 0;
+done:
+	sc_workerpool_push(&s->wp, w);
+	return rc;
+}
+
 int sc_ctl_expire(sc *s, si *index)
 {
 	ss_mutexlock(&s->lock);
@@ -26803,13 +26913,16 @@ so *se_new(void);
 
 typedef struct sedocument sedocument;
 
+/* maximum number of scheme fields, including meta fields */
+#define SE_DOCUMENT_FIELDS 64
+
 struct sedocument {
 	so        o;
 	int       created;
 	svv      *v;
 	ssorder   order;
 	int       orderset;
-	sfv       fields[8];
+	sfv       fields[SE_DOCUMENT_FIELDS];
 	int       fields_count;
 	int       fields_count_keys;
 	void     *prefix;
@@ -26936,6 +27049,18 @@ struct secursor {
 	int      read_cache;
 	sedb    *read_db;
 	sicache *cache;
+	/* transaction, which uncommitted writes are visible */
+	sx      *tx;
+	/* key of the next read and the document it was built from,
+	 * the returned document isn't consumed by the next read */
+	so      *pos;
+	so      *last;
+	/* set if the last read has failed with error */
+	int      error;
+	/* transaction log positions ordered by key */
+	ssbuf    txindex;
+	uint32_t txindex_db;
+	uint32_t txindex_count;
 };
 
 so *se_cursornew(se*, uint64_t);
@@ -27375,6 +27500,16 @@ se_confscheduler_run(srconf *c, srconfstmt *s)
 	return sc_ctl_call(&e->scheduler, vlsn);
 }
 
+static inline int
+se_confscheduler_checkpoint(srconf *c, srconfstmt *s)
+{
+	if (s->op != SR_WRITE)
+		return se_confv(c, s);
+	se *e = s->ptr;
+	uint64_t vlsn = sx_vlsn(&e->xm);
+	return sc_ctl_checkpoint(&e->scheduler, vlsn);
+}
+
 static inline srconf*
 se_confscheduler(se *e, srconf **pc, int serialize)
 {
@@ -27382,8 +27517,10 @@ se_confscheduler(se *e, srconf **pc, int serialize)
 	srconf *prev;
 	srconf *p = NULL;
 	sr_c(&p, pc, se_confv_offline, "threads", SS_U32, &e->conf.threads);
-	if (! serialize)
+	if (! serialize) {
 		sr_c(&p, pc, se_confscheduler_run, "run", SS_FUNCTION, NULL);
+		sr_c(&p, pc, se_confscheduler_checkpoint, "checkpoint", SS_FUNCTION, NULL);
+	}
 	prev = p;
 	sslist *i;
 	ss_listforeach(&e->scheduler.wp.list, i) {
@@ -27629,7 +27766,8 @@ se_confdb_scheme(srconf *c ssunused, srconfstmt *s)
 		sr_error(s->r->e, "write to %s is offline-only", s->path);
 		return -1;
 	}
-	if (ssunlikely(db->scheme->scheme.fields_count == 8)) {
+	/* reserve space for _flags and _lsn meta fields */
+	if (ssunlikely(db->scheme->scheme.fields_count >= SE_DOCUMENT_FIELDS - 2)) {
 		sr_error(s->r->e, "%s", "fields number limit reached");
 		return -1;
 	}
@@ -27779,8 +27917,8 @@ se_confdb(se *e, seconfrt *rt ssunused, srconf **pc, int serialize)
 		sr_C(&p, pc, se_confv_dboffline, "sync", SS_U32, &o->scheme->sync, 0, o);
 		sr_C(&p, pc, se_confv_dboffline, "expire", SS_U32, &o->scheme->expire, 0, o);
 		sr_C(&p, pc, se_confv_dboffline, "compression", SS_STRINGPTR, &o->scheme->compression_sz, 0, o);
-		sr_C(&p, pc, se_confdb_upsert, "comparator", SS_STRING, NULL, 0, o);
-		sr_C(&p, pc, se_confdb_upsertarg, "comparator_arg", SS_STRING, NULL, 0, o);
+		sr_C(&p, pc, se_confdb_comparator, "comparator", SS_STRING, NULL, 0, o);
+		sr_C(&p, pc, se_confdb_comparatorarg, "comparator_arg", SS_STRING, NULL, 0, o);
 		sr_C(&p, pc, se_confdb_upsert, "upsert", SS_STRING, NULL, 0, o);
 		sr_C(&p, pc, se_confdb_upsertarg, "upsert_arg", SS_STRING, NULL, 0, o);
 
@@ -28367,6 +28505,11 @@ se_cursordestroy(so *o)
 {
 	secursor *c = se_cast(o, secursor*, SECURSOR);
 	se *e = se_of(&c->o);
+	if (c->pos)
+		so_destroy(c->pos);
+	c->pos = NULL;
+	c->last = NULL;
+	ss_buffree(&c->txindex, &e->a);
 	sx_rollback(&c->t);
 	if (c->cache)
 		si_cachepool_push(c->cache);
@@ -28381,6 +28524,217 @@ se_cursordestroy(so *o)
 	return 0;
 }
 
+static so*
+se_cursorkey(sedb *db, svv *v, ssorder order, void *prefix,
+             uint32_t prefix_size)
+{
+	se *e = se_of(&db->o);
+	sedocument *key =
+		(sedocument*)se_document_new(e, &db->o, NULL);
+	if (ssunlikely(key == NULL))
+		return NULL;
+	key->order = order;
+	key->orderset = 1;
+	key->created = 1;
+	if (prefix) {
+		key->prefix_copy = ss_malloc(&e->a, prefix_size);
+		if (ssunlikely(key->prefix_copy == NULL))
+			goto error;
+		memcpy(key->prefix_copy, prefix, prefix_size);
+		key->prefix = key->prefix_copy;
+		key->prefix_size = prefix_size;
+	}
+	key->v = sv_vbuildraw(db->r, sv_vpointer(v));
+	if (ssunlikely(key->v == NULL))
+		goto error;
+	sf_flagsset(db->r->scheme, sv_vpointer(key->v), SVGET);
+	return &key->o;
+error:
+	so_destroy(&key->o);
+	sr_oom(&e->error);
+	return NULL;
+}
+
+static inline char*
+se_cursortx_key(secursor *c, uint32_t pos)
+{
+	return sv_vpointer(sv_logat(c->tx->log, pos)->v);
+}
+
+static void
+se_cursortx_sort(secursor *c, sr *r, uint32_t *a, uint32_t *tmp, int count)
+{
+	if (count < 2)
+		return;
+	int half = count / 2;
+	se_cursortx_sort(c, r, a, tmp, half);
+	se_cursortx_sort(c, r, a + half, tmp, count - half);
+	int i = 0, j = half, k = 0;
+	while (i < half && j < count) {
+		if (sf_compare(r->scheme, se_cursortx_key(c, a[j]),
+		               se_cursortx_key(c, a[i])) < 0)
+			tmp[k++] = a[j++];
+		else
+			tmp[k++] = a[i++];
+	}
+	while (i < half)
+		tmp[k++] = a[i++];
+	while (j < count)
+		tmp[k++] = a[j++];
+	memcpy(a, tmp, count * sizeof(uint32_t));
+}
+
+/* build index of the transaction log positions of the database
+ * ordered by key, it is rebuilt only when the log grows,
+ * because replaced versions keep their keys */
+static int
+se_cursortx_index(secursor *c, sedb *db)
+{
+	svlog *log = c->tx->log;
+	uint32_t id = db->coindex.dsn;
+	if (c->txindex_db == id && c->txindex_count == (uint32_t)sv_logcount(log))
+		return 0;
+	se *e = se_of(&c->o);
+	ss_bufreset(&c->txindex);
+	c->txindex_db = UINT32_MAX;
+	int count = 0;
+	if (id < ss_bufused(&log->index) / sizeof(svlogindex)) {
+		uint32_t n = sv_logindex(log, id)->head;
+		while (n != UINT32_MAX) {
+			if (ssunlikely(ss_bufadd(&c->txindex, &e->a, &n, sizeof(n)) == -1))
+				return sr_oom(&e->error);
+			n = sv_logat(log, n)->next;
+			count++;
+		}
+	}
+	if (count > 1) {
+		uint32_t *tmp = ss_malloc(&e->a, count * sizeof(uint32_t));
+		if (ssunlikely(tmp == NULL))
+			return sr_oom(&e->error);
+		se_cursortx_sort(c, db->r, (uint32_t*)c->txindex.s, tmp, count);
+		ss_free(&e->a, tmp);
+	}
+	c->txindex_db = id;
+	c->txindex_count = sv_logcount(log);
+	return 0;
+}
+
+/* find the nearest key written by the cursor transaction */
+static int
+se_cursortx_match(secursor *c, sedb *db, svv *pos, ssorder order,
+                  void *prefix, uint32_t prefix_size, svv **match)
+{
+	*match = NULL;
+	int rc = se_cursortx_index(c, db);
+	if (ssunlikely(rc == -1))
+		return -1;
+	sr *r = db->r;
+	uint32_t *index = (uint32_t*)c->txindex.s;
+	int count = ss_bufused(&c->txindex) / sizeof(uint32_t);
+	int forward = order == SS_GT || order == SS_GTE;
+	/* find the first position, which key is greater than
+	 * pos (or equal for GTE and LT) */
+	int lo = 0, hi = count;
+	while (lo < hi) {
+		int mid = lo + (hi - lo) / 2;
+		rc = sf_compare(r->scheme, se_cursortx_key(c, index[mid]),
+		                sv_vpointer(pos));
+		if (rc < 0 || (rc == 0 && (order == SS_GT || order == SS_LTE)))
+			lo = mid + 1;
+		else
+			hi = mid;
+	}
+	int i = forward ? lo : lo - 1;
+	for (; i >= 0 && i < count; i += forward ? 1 : -1) {
+		svv *v = sv_logat(c->tx->log, index[i])->v;
+		if (sv_vflags(v, r) & SVGET)
+			continue;
+		if (prefix && !sf_compareprefix(r->scheme, prefix, prefix_size,
+		                                sv_vpointer(v)))
+			continue;
+		*match = v;
+		break;
+	}
+	return 0;
+}
+
+/* merge committed documents with writes of the cursor transaction */
+static sedocument*
+se_cursorget_tx(secursor *c, sedb *db, sedocument *key)
+{
+	se *e = se_of(&c->o);
+	if (key->order == SS_EQ)
+		return (sedocument*)se_read(db, key, c->tx, c->t.vlsn, c->cache);
+	sedocument *ret = NULL;
+	int rc = se_document_validate_ro(key, &db->o);
+	if (ssunlikely(rc == -1))
+		goto done;
+	rc = se_document_createkey(key);
+	if (ssunlikely(rc == -1))
+		goto done;
+	ssorder order = key->order;
+	int forward = order == SS_GT || order == SS_GTE;
+	svv *pos = key->v;
+	for (;;) {
+		sedocument *next =
+			(sedocument*)se_cursorkey(db, pos, order, key->prefix_copy,
+			                          key->prefix_size);
+		if (ssunlikely(next == NULL))
+			goto done;
+		next = (sedocument*)se_read(db, next, NULL, c->t.vlsn, c->cache);
+		svv *own;
+		rc = se_cursortx_match(c, db, pos, order, key->prefix_copy,
+		                       key->prefix_size, &own);
+		if (ssunlikely(rc == -1)) {
+			if (next)
+				so_destroy(&next->o);
+			goto done;
+		}
+		if (own == NULL) {
+			ret = next;
+			goto done;
+		}
+		if (next) {
+			rc = sf_compare(db->r->scheme, sv_vpointer(next->v),
+			                sv_vpointer(own));
+			if (forward ? rc < 0 : rc > 0) {
+				ret = next;
+				goto done;
+			}
+			so_destroy(&next->o);
+		}
+		pos = own;
+		if (! (sv_vflags(own, db->r) & SVDELETE))
+			break;
+		/* skip document deleted by the transaction */
+		order = forward ? SS_GT : SS_LT;
+	}
+	sedocument *get =
+		(sedocument*)se_cursorkey(db, pos, SS_EQ, NULL, 0);
+	if (ssunlikely(get == NULL))
+		goto done;
+	ret = (sedocument*)se_read(db, get, c->tx, c->t.vlsn, c->cache);
+	if (ret == NULL)
+		goto done;
+	ret->orderset = 1;
+	ret->order = forward ? SS_GT : SS_LT;
+	if (key->prefix_copy) {
+		ret->prefix_copy = ss_malloc(&e->a, key->prefix_size);
+		if (ssunlikely(ret->prefix_copy == NULL)) {
+			so_destroy(&ret->o);
+			ret = NULL;
+			sr_oom(&e->error);
+			goto done;
+		}
+		memcpy(ret->prefix_copy, key->prefix_copy, key->prefix_size);
+		ret->prefix = ret->prefix_copy;
+		ret->prefix_size = key->prefix_size;
+	}
+done:
+	so_destroy(&key->o);
+	return ret;
+}
+
 static void*
 se_cursorget(so *o, so *v)
 {
@@ -28389,18 +28743,52 @@ se_cursorget(so *o, so *v)
 	sedb *db = se_cast(v->parent, sedb*, SEDB);
 	if (ssunlikely(c->read_db == NULL))
 		c->read_db = db;
+	uint64_t errors = sr_errors_thread;
+	c->error = 0;
+	if (v == c->last) {
+		/* continue from the previous document, which
+		 * stays owned by the caller */
+		c->last = NULL;
+		key = se_cast(c->pos, sedocument*, SEDOCUMENT);
+		c->pos = NULL;
+		if (ssunlikely(key == NULL)) {
+			c->error = 1;
+			return NULL;
+		}
+	} else if (c->pos) {
+		so_destroy(c->pos);
+		c->pos = NULL;
+		c->last = NULL;
+	}
 	if (ssunlikely(! key->orderset))
 		key->order = SS_GTE;
-	sedocument *ret =
-		(sedocument*)se_read(db, key, NULL, c->t.vlsn, c->cache);
-	if (ret == NULL)
+	sedocument *ret;
+	if (c->tx)
+		ret = se_cursorget_tx(c, db, key);
+	else
+		ret = (sedocument*)se_read(db, key, NULL, c->t.vlsn, c->cache);
+	if (ret == NULL) {
+		c->error = sr_errors_thread != errors;
 		return NULL;
+	}
+	c->pos = se_cursorkey(db, ret->v, ret->order, ret->prefix_copy,
+	                      ret->prefix_size);
+	c->last = &ret->o;
 	c->read_disk  += ret->read_disk;
 	c->read_cache += ret->read_cache;
 	c->ops++;
 	return ret;
 }
 
+static int64_t
+se_cursorget_int(so *o, const char *path)
+{
+	secursor *c = se_cast(o, secursor*, SECURSOR);
+	if (strcmp(path, "error") == 0)
+		return c->error;
+	return -1;
+}
+
 static soif secursorif =
 {
 	.open         = NULL,
@@ -28411,7 +28799,7 @@ static soif secursorif =
 	.setint       = NULL,
 	.getobject    = NULL,
 	.getstring    = NULL,
-	.getint       = NULL,
+	.getint       = se_cursorget_int,
 	.set          = NULL,
 	.upsert       = NULL,
 	.del          = NULL,
@@ -28439,6 +28827,13 @@ so *se_cursornew(se *e, uint64_t vlsn)
 	c->read_disk = 0;
 	c->read_cache = 0;
 	c->read_db = NULL;
+	c->tx = NULL;
+	c->pos = NULL;
+	c->last = NULL;
+	c->error = 0;
+	ss_bufinit(&c->txindex);
+	c->txindex_db = UINT32_MAX;
+	c->txindex_count = 0;
 	c->t.state = SX_UNDEF;
 	c->cache = si_cachepool_pop(&e->cachepool);
 	if (ssunlikely(c->cache == NULL)) {
@@ -28554,6 +28949,11 @@ se_dbscheme_set(sedb *db)
 		sr_error(&e->error, "incomplete scheme", s->name);
 		return -1;
 	}
+	if (ssunlikely(s->scheme.fields_count > SE_DOCUMENT_FIELDS)) {
+		sr_error(&e->error, "too many fields in scheme: %d, maximum is %d",
+		         s->scheme.fields_count, SE_DOCUMENT_FIELDS);
+		return -1;
+	}
 	/* validate io settings */
 	if (s->mmap && s->direct_io) {
 		sr_error(&e->error, "%s", "incompatible options: mmap and direct_io");
@@ -29806,6 +30206,50 @@ se_txprepare(sx *x, svv *v, so *o, void *ptr)
 	return rc;
 }
 
+static inline int
+se_txdoprepare(setx *t, int recover)
+{
+	se *e = se_of(&t->o);
+	sicache *cache = NULL;
+	sxpreparef prepare = NULL;
+	if (! recover) {
+		prepare = se_txprepare;
+		cache = si_cachepool_pop(&e->cachepool);
+		if (ssunlikely(cache == NULL))
+			return sr_oom(&e->error);
+	}
+	sxstate s = sx_prepare(&t->t, prepare, cache);
+	if (cache)
+		si_cachepool_push(cache);
+	if (s == SX_LOCK) {
+		sr_statxm_lock(&e->xm_stat);
+		return 2;
+	}
+	if (s == SX_ROLLBACK) {
+		sx_rollback(&t->t);
+		se_txend(t, 0, 1);
+		return 1;
+	}
+	assert(s == SX_PREPARE);
+	return 0;
+}
+
+static int
+se_txprepare_api(so *o)
+{
+	setx *t = se_cast(o, setx*, SETX);
+	se *e = se_of(o);
+	int status = sr_status(&e->status);
+	if (ssunlikely(! sr_statusactive_is(status)))
+		return -1;
+	/* already prepared transaction can only be
+	 * commited or rolled back */
+	if (t->t.state == SX_PREPARE)
+		return 0;
+	int recover = (status == SR_RECOVER);
+	return se_txdoprepare(t, recover);
+}
+
 static int
 se_txcommit(so *o)
 {
@@ -29820,30 +30264,13 @@ se_txcommit(so *o)
 	/* prepare transaction */
 	if (t->t.state == SX_READY || t->t.state == SX_LOCK)
 	{
-		sicache *cache = NULL;
-		sxpreparef prepare = NULL;
-		if (! recover) {
-			prepare = se_txprepare;
-			cache = si_cachepool_pop(&e->cachepool);
-			if (ssunlikely(cache == NULL))
-				return sr_oom(&e->error);
-		}
-		sxstate s = sx_prepare(&t->t, prepare, cache);
-		if (cache)
-			si_cachepool_push(cache);
-		if (s == SX_LOCK) {
-			sr_statxm_lock(&e->xm_stat);
-			return 2;
-		}
-		if (s == SX_ROLLBACK) {
-			sx_rollback(&t->t);
-			se_txend(t, 0, 1);
-			return 1;
-		}
-		assert(s == SX_PREPARE);
-
-		sx_commit(&t->t);
+		rc = se_txdoprepare(t, recover);
+		if (ssunlikely(rc != 0))
+			return rc;
 	}
+	/* commit prepared transaction */
+	if (t->t.state == SX_PREPARE)
+		sx_commit(&t->t);
 	assert(t->t.state == SX_COMMIT);
 
 	/* wal write and multi-index write */
@@ -29861,6 +30288,11 @@ se_txcommit(so *o)
 			assert(db != NULL);
 			sv_vunref(db->r, lv->v);
 		}
+		/* transaction log is freed, so the transaction
+		 * can't be commited again or rollbacked: release it
+		 * and report it by a distinct code */
+		se_txend(t, 0, 0);
+		return -2;
 	}
 	se_txend(t, 0, 0);
 	return rc;
@@ -29883,9 +30315,24 @@ se_txget_int(so *o, const char *path)
 	setx *t = se_cast(o, setx*, SETX);
 	if (strcmp(path, "deadlock") == 0)
 		return sx_deadlock(&t->t);
+	if (strcmp(path, "vlsn") == 0)
+		return t->t.vlsn;
 	return -1;
 }
 
+static void*
+se_txcursor(so *o)
+{
+	setx *t = se_cast(o, setx*, SETX);
+	se *e = se_of(o);
+	/* cursor reads at transaction snapshot and sees
+	 * uncommitted writes of the transaction */
+	secursor *c = (secursor*)se_cursornew(e, t->t.vlsn);
+	if (sslikely(c))
+		c->tx = &t->t;
+	return c;
+}
+
 static soif setxif =
 {
 	.open         = NULL,
@@ -29902,9 +30349,9 @@ static soif setxif =
 	.del          = se_txdelete,
 	.get          = se_txget,
 	.begin        = NULL,
-	.prepare      = NULL,
+	.prepare      = se_txprepare_api,
 	.commit       = se_txcommit,
-	.cursor       = NULL
+	.cursor       = se_txcursor
 };
 
 so *se_txnew(se *e)
@@ -0,0 +1,232 @@
+package sophia
+
+import (
+	"fmt"
+	"time"
+)
+
+// Distribution is a summary of values collected by sophia per operation,
+// e.g. number of disk reads per get operation.
+// Sophia reports it as "min max avg" string.
+type Distribution struct {
+	Min uint64
+	Max uint64
+	Avg float64
+}
+
+// Latency is a summary of operation latencies collected by sophia
+type Latency struct {
+	Min time.Duration
+	Max time.Duration
+	Avg time.Duration
+}
+
+// OperationStats is statistics of database operation
+type OperationStats struct {
+	// Count is a number of operations
+	Count int64
+	// Latency of operations
+	Latency Latency
+}
+
+// TransactionStats is statistics of environment transactions
+type TransactionStats struct {
+	// OnlineRW is a number of active read-write transactions
+	OnlineRW int64
+	// OnlineRO is a number of active read-only transactions
+	OnlineRO int64
+	// Commits is a number of committed transactions
+	Commits int64
+	// Rollbacks is a number of rollbacked transactions
+	Rollbacks int64
+	// Conflicts is a number of transactions rollbacked because of conflict
+	Conflicts int64
+	// Locks is a number of transactions commits, which returned TxLock
+	Locks int64
+	// Latency of transactions
+	Latency Latency
+	// Statements is a distribution of statements count in transactions
+	Statements Distribution
+	// VLSN is the lowest LSN visible for active transactions
+	VLSN int64
+	// GC is a number of versions waiting for garbage collection
+	GC int64
+}
+
+// EnvironmentStats is statistics of environment
+type EnvironmentStats struct {
+	// LSN is a log sequence number
+	LSN int64
+	// TSN is a transaction sequence number
+	TSN int64
+	// NSN is a node sequence number
+	NSN int64
+	// DSN is a database sequence number
+	DSN int64
+	// BSN is a backup sequence number
+	BSN int64
+	// LFSN is a log file sequence number
+	LFSN int64
+	// LogFiles is a number of log files
+	LogFiles int64
+	// Transactions statistics
+	Transactions TransactionStats
+}
+
+// DatabaseStats is statistics of database
+type DatabaseStats struct {
+	// Documents is a number of documents in memory
+	Documents int64
+	// DocumentsUsed is a memory used by documents in bytes
+	DocumentsUsed int64
+	// MemoryUsed is a memory used by index in bytes
+	MemoryUsed int64
+	// Size is a size of database files in bytes
+	Size int64
+	// SizeUncompressed is a size of database files without compression in bytes
+	SizeUncompressed int64
+	// Count is a total number of documents
+	Count int64
+	// CountDup is a number of duplicates of documents
+	CountDup int64
+	// ReadDisk is a number of disk reads
+	ReadDisk int64
+	// ReadCache is a number of cache reads
+	ReadCache int64
+	// NodeCount is a number of nodes
+	NodeCount int64
+	// PageCount is a number of pages
+	PageCount int64
+
+	// Fields is a distribution of fields count in documents
+	Fields Distribution
+
+	Set    OperationStats
+	Delete OperationStats
+	Upsert OperationStats
+	Get    OperationStats
+	Cursor OperationStats
+	PRead  OperationStats
+
+	GetReadDisk     Distribution
+	GetReadCache    Distribution
+	CursorReadDisk  Distribution
+	CursorReadCache Distribution
+	CursorOps       Distribution
+}
+
+// Stats returns statistics of the environment.
+// It can be called concurrently with NewDatabase and Close.
+func (env *Environment) Stats() (EnvironmentStats, error) {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	if env.ptr == nil {
+		return EnvironmentStats{}, ErrEnvironmentClosed
+	}
+	r := statsReader{env: env}
+	stats := EnvironmentStats{
+		LSN:      env.GetInt("metric.lsn"),
+		TSN:      env.GetInt("metric.tsn"),
+		NSN:      env.GetInt("metric.nsn"),
+		DSN:      env.GetInt("metric.dsn"),
+		BSN:      env.GetInt(keyBSN),
+		LFSN:     env.GetInt("metric.lfsn"),
+		LogFiles: env.GetInt("log.files"),
+		Transactions: TransactionStats{
+			OnlineRW:   env.GetInt("transaction.online_rw"),
+			OnlineRO:   env.GetInt("transaction.online_ro"),
+			Commits:    env.GetInt("transaction.commit"),
+			Rollbacks:  env.GetInt("transaction.rollback"),
+			Conflicts:  env.GetInt("transaction.conflict"),
+			Locks:      env.GetInt("transaction.lock"),
+			Latency:    r.latency("transaction.latency"),
+			Statements: r.distribution("transaction.log"),
+			VLSN:       env.GetInt("transaction.vlsn"),
+			GC:         env.GetInt("transaction.gc"),
+		},
+	}
+	return stats, r.err
+}
+
+// Stats returns statistics of the database.
+// It can be called concurrently with NewDatabase and Close of the environment.
+func (db *Database) Stats() (DatabaseStats, error) {
+	db.env.mu.RLock()
+	defer db.env.mu.RUnlock()
+	if db.env.ptr == nil {
+		return DatabaseStats{}, ErrEnvironmentClosed
+	}
+	r := statsReader{env: db.env, prefix: fmt.Sprintf("db.%v.", db.name)}
+	stats := DatabaseStats{
+		Documents:        r.int("stat.documents"),
+		DocumentsUsed:    r.int("stat.documents_used"),
+		MemoryUsed:       r.int("index.memory_used"),
+		Size:             r.int("index.size"),
+		SizeUncompressed: r.int("index.size_uncompressed"),
+		Count:            r.int("index.count"),
+		CountDup:         r.int("index.count_dup"),
+		ReadDisk:         r.int("index.read_disk"),
+		ReadCache:        r.int("index.read_cache"),
+		NodeCount:        r.int("index.node_count"),
+		PageCount:        r.int("index.page_count"),
+		Fields:           r.distribution("stat.field"),
+		Set:              r.operation("stat.set"),
+		Delete:           r.operation("stat.delete"),
+		Upsert:           r.operation("stat.upsert"),
+		Get:              r.operation("stat.get"),
+		Cursor:           r.operation("stat.cursor"),
+		PRead:            r.operation("stat.pread"),
+		GetReadDisk:      r.distribution("stat.get_read_disk"),
+		GetReadCache:     r.distribution("stat.get_read_cache"),
+		CursorReadDisk:   r.distribution("stat.cursor_read_disk"),
+		CursorReadCache:  r.distribution("stat.cursor_read_cache"),
+		CursorOps:        r.distribution("stat.cursor_ops"),
+	}
+	return stats, r.err
+}
+
+// statsReader reads statistics values and keeps the first parsing error
+type statsReader struct {
+	env    *Environment
+	prefix string
+	err    error
+}
+
+func (r *statsReader) int(key string) int64 {
+	return r.env.GetInt(r.prefix + key)
+}
+
+func (r *statsReader) operation(key string) OperationStats {
+	return OperationStats{
+		Count:   r.int(key),
+		Latency: r.latency(key + "_latency"),
+	}
+}
+
+func (r *statsReader) latency(key string) Latency {
+	return latencyOf(r.distribution(key))
+}
+
+// latencyOf converts distribution of latencies in microseconds to Latency
+func latencyOf(d Distribution) Latency {
+	return Latency{
+		Min: time.Duration(d.Min) * time.Microsecond,
+		Max: time.Duration(d.Max) * time.Microsecond,
+		Avg: time.Duration(d.Avg * float64(time.Microsecond)),
+	}
+}
+
+func (r *statsReader) distribution(key string) Distribution {
+	d, err := parseDistribution(r.env.configString(r.prefix + key))
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("failed to parse '%v': %v", r.prefix+key, err)
+	}
+	return d
+}
+
+// parseDistribution parses sophia "min max avg" statistics string
+func parseDistribution(str string) (Distribution, error) {
+	var d Distribution
+	_, err := fmt.Sscanf(str, "%d %d %g", &d.Min, &d.Max, &d.Avg)
+	return d, err
+}
@@ -0,0 +1,90 @@
+package sophia
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDistribution(t *testing.T) {
+	d, err := parseDistribution("1 10 2.5")
+	require.Nil(t, err)
+	require.Equal(t, Distribution{Min: 1, Max: 10, Avg: 2.5}, d)
+
+	_, err = parseDistribution("")
+	require.NotNil(t, err)
+
+	require.Equal(t, Latency{
+		Min: time.Microsecond,
+		Max: 10 * time.Microsecond,
+		Avg: 2500 * time.Nanosecond,
+	}, latencyOf(d))
+}
+
+func TestStats(t *testing.T) {
+	const (
+		keyPath      = "key"
+		valuePath    = "value"
+		recordsCount = 10
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+
+	for i := 0; i < recordsCount; i++ {
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, fmt.Sprint(i)))
+		require.True(t, doc.Set(valuePath, fmt.Sprint(i)))
+		require.Nil(t, db.Set(doc))
+		doc.Free()
+
+		doc = db.Document()
+		require.True(t, doc.Set(keyPath, fmt.Sprint(i)))
+		d, err := db.Get(doc)
+		doc.Free()
+		require.Nil(t, err)
+		d.Destroy()
+	}
+
+	tx, err := env.BeginTx()
+	require.Nil(t, err)
+	require.Equal(t, TxOk, tx.Commit())
+
+	envStats, err := env.Stats()
+	require.Nil(t, err)
+	require.True(t, envStats.LSN >= recordsCount)
+	require.Equal(t, int64(1), envStats.Transactions.Commits)
+
+	dbStats, err := db.Stats()
+	require.Nil(t, err)
+	require.Equal(t, int64(recordsCount), dbStats.Documents)
+	require.Equal(t, int64(recordsCount), dbStats.Count)
+	require.Equal(t, int64(recordsCount), dbStats.Set.Count)
+	require.Equal(t, int64(recordsCount), dbStats.Get.Count)
+	require.True(t, dbStats.Set.Latency.Max >= dbStats.Set.Latency.Min)
+	require.True(t, dbStats.DocumentsUsed > 0)
+
+	require.Nil(t, env.Close())
+	_, err = env.Stats()
+	require.Equal(t, ErrEnvironmentClosed, err)
+	_, err = db.Stats()
+	require.Equal(t, ErrEnvironmentClosed, err)
+}
@@ -1,6 +1,11 @@
 package sophia
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
 
 // TxStatus transactional status
 type TxStatus int
@@ -27,18 +32,137 @@ const (
 // Any number of databases can be involved in a multi-statement transaction.
 type Transaction struct {
 	*dataStore
+	// finished is set when the transaction object has been released by sophia
+	finished bool
+	// cursorsMu guards cursors, they can be closed by goroutines, which use them
+	cursorsMu sync.Mutex
+	// open cursors created by Cursor(), they are closed when the transaction is finished
+	cursors map[*Cursor]struct{}
 }
 
+// ErrTxFinished is returned on usage of a committed or rollbacked transaction
+var ErrTxFinished = errors.New("usage of finished transaction")
+
+// Prepare runs the first phase of two-phase commit: checks the transaction
+// for conflicts with concurrent transactions without committing it.
+// TxOk means that transaction is prepared and will not be rollbacked by
+// concurrent transactions, so it can be safely committed later by Commit()
+// or discarded by Rollback().
+// TxRollback means that transaction has been rollbacked and destroyed,
+// neither Commit() nor Rollback() should be called after it.
+// TxLock means that transaction is waiting for concurrent transaction
+// to complete, Prepare() should be retried later.
+func (tx *Transaction) Prepare() TxStatus {
+	if tx.finished {
+		return TxError
+	}
+	status := TxStatus(spPrepare(tx.ptr))
+	if status == TxRollback {
+		tx.finish()
+		tx.live.release("rollback on Prepare")
+	}
+	return status
+}
+
+// txCommitFailed is returned by sp_commit, when the transaction has been prepared,
+// but failed to be written, e.g. because of log write error. Sophia releases the transaction then.
+const txCommitFailed = -2
+
 // Commit commits the transaction and returns it's status.
 // Any error happened during multi-statement transaction does not rollback a transaction.
+// Transaction is released on TxOk and TxRollback.
+// On TxLock it stays alive, so it can be committed again or rollbacked.
+// On TxError it stays alive only if it has failed before being prepared, e.g. if the environment
+// isn't open or the transaction can't be prepared, it can be rollbacked then.
+// If the prepared transaction fails to be written, it is released, and it's writes are lost.
 func (tx *Transaction) Commit() TxStatus {
-	return TxStatus(spCommit(tx.ptr))
+	if tx.finished {
+		return TxError
+	}
+	tx.closeCursors()
+	status := TxStatus(spCommit(tx.ptr))
+	switch status {
+	case TxOk, TxRollback:
+		tx.finished = true
+		tx.live.release("Commit")
+	case txCommitFailed:
+		tx.finished = true
+		tx.live.release("failed Commit")
+		status = TxError
+	}
+	return status
+}
+
+// CommitContext commits the transaction like Commit, but commit of locked transaction
+// is retried with backoff of environment RetryPolicy, until it is committed, rollbacked or ctx is done.
+// If ctx is done, it's error is returned and the transaction isn't released,
+// it can be committed again or rollbacked.
+func (tx *Transaction) CommitContext(ctx context.Context) (TxStatus, error) {
+	if tx.finished {
+		return TxError, ErrTxFinished
+	}
+	return tx.env.commit(ctx, tx, tx.env.loadRetryPolicy())
 }
 
 // Rollback rollbacks transaction and destroy transaction object.
 func (tx *Transaction) Rollback() error {
+	if tx.finished {
+		return ErrTxFinished
+	}
+	tx.finish()
+	tx.live.release("Rollback")
 	if !spDestroy(tx.ptr) {
 		return errors.New("tx: failed to rollback")
 	}
 	return nil
 }
+
+// Cursor returns a Cursor for iterating over rows of the database inside the transaction.
+// Cursor sees rows as they were at the moment the transaction began
+// together with uncommitted changes made by the transaction itself.
+// Cursor is closed by Commit() or Rollback() if it is still open.
+func (tx *Transaction) Cursor(db *Database, doc Document) (*Cursor, error) {
+	if tx.finished {
+		return nil, ErrTxFinished
+	}
+	if doc.db != nil && doc.db != db {
+		return nil, fmt.Errorf("failed to create cursor: document of database '%v' is used for '%v'", doc.db.name, db.name)
+	}
+	cursor, err := newCursor(tx.ptr, tx.env, doc)
+	if err != nil {
+		return nil, err
+	}
+	cursor.tx = tx
+	tx.cursorsMu.Lock()
+	if tx.cursors == nil {
+		tx.cursors = make(map[*Cursor]struct{})
+	}
+	tx.cursors[cursor] = struct{}{}
+	tx.cursorsMu.Unlock()
+	return cursor, nil
+}
+
+// finish marks the transaction as finished and closes it's cursors
+func (tx *Transaction) finish() {
+	tx.finished = true
+	tx.closeCursors()
+}
+
+// closeCursors closes cursors of the transaction, which are still open.
+// Sophia cursor refers to the transaction, so it can't outlive it.
+func (tx *Transaction) closeCursors() {
+	tx.cursorsMu.Lock()
+	cursors := tx.cursors
+	tx.cursors = nil
+	tx.cursorsMu.Unlock()
+	for cursor := range cursors {
+		cursor.Close()
+	}
+}
+
+// removeCursor forgets closed cursor of the transaction
+func (tx *Transaction) removeCursor(cursor *Cursor) {
+	tx.cursorsMu.Lock()
+	delete(tx.cursors, cursor)
+	tx.cursorsMu.Unlock()
+}
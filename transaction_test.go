@@ -3,6 +3,8 @@ package sophia
 import (
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -283,3 +285,348 @@ func TestConcurrentTx(t *testing.T) {
 	require.Equal(t, expectedValue1, value)
 	d.Destroy()
 }
+
+func TestTxPrepareRollback(t *testing.T) {
+	const (
+		keyPath       = "key"
+		valuePath     = "value"
+		expectedKey   = "key1"
+		expectedValue = "value1"
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	tx, err := env.BeginTx()
+	require.Nil(t, err)
+
+	doc := db.Document()
+	require.True(t, doc.Set(keyPath, expectedKey))
+	require.True(t, doc.Set(valuePath, expectedValue))
+
+	require.Nil(t, tx.Set(doc))
+	doc.Free()
+
+	require.Equal(t, TxOk, tx.Prepare())
+	require.Equal(t, TxOk, tx.Prepare())
+	require.Nil(t, tx.Rollback())
+	require.Equal(t, ErrTxFinished, tx.Rollback())
+	require.Equal(t, TxError, tx.Commit())
+
+	doc = db.Document()
+	require.True(t, doc.Set(keyPath, expectedKey))
+
+	d, err := db.Get(doc)
+	require.True(t, d.IsEmpty())
+	require.Equal(t, ErrNotFound, err)
+	doc.Free()
+}
+
+func TestTxCommitError(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+	defer env.Close()
+
+	// transaction can't be committed before environment is opened
+	tx, err := env.BeginTx()
+	require.Nil(t, err)
+	require.Equal(t, TxError, tx.Commit())
+	require.Equal(t, TxError, tx.Commit())
+	require.Nil(t, tx.Rollback())
+	require.Equal(t, ErrTxFinished, tx.Rollback())
+}
+
+func TestTxCommitWriteError(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	tx, err := env.BeginTx()
+	require.Nil(t, err)
+	doc := db.Document()
+	require.True(t, doc.SetString("key", "key"))
+	require.True(t, doc.SetString("value", "value"))
+	require.Nil(t, tx.Set(doc))
+	doc.Free()
+
+	// writes to the log fail with EFBIG, while file size limit is 0
+	var limit syscall.Rlimit
+	require.Nil(t, syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit))
+	signal.Ignore(syscall.SIGXFSZ)
+	defer signal.Reset(syscall.SIGXFSZ)
+	require.Nil(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: 0, Max: limit.Max}))
+	status := tx.Commit()
+	require.Nil(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit))
+
+	// prepared transaction is released by failed write
+	require.Equal(t, TxError, status)
+	require.Error(t, env.Error())
+	require.Equal(t, TxError, tx.Commit())
+	require.Equal(t, ErrTxFinished, tx.Rollback())
+}
+
+func TestTxPrepareConflict(t *testing.T) {
+	const (
+		keyPath        = "key"
+		valuePath      = "value"
+		expectedKey    = "key1"
+		expectedValue1 = "value1"
+		expectedValue2 = "value2"
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	tx1, err := env.BeginTx()
+	require.Nil(t, err)
+	tx2, err := env.BeginTx()
+	require.Nil(t, err)
+
+	doc := db.Document()
+	require.True(t, doc.Set(keyPath, expectedKey))
+	require.True(t, doc.Set(valuePath, expectedValue1))
+	require.Nil(t, tx1.Set(doc))
+	doc.Free()
+
+	doc = db.Document()
+	require.True(t, doc.Set(keyPath, expectedKey))
+	require.True(t, doc.Set(valuePath, expectedValue2))
+	require.Nil(t, tx2.Set(doc))
+	doc.Free()
+
+	// tx2 has to wait for tx1 to complete
+	require.Equal(t, TxLock, tx2.Prepare())
+	require.Equal(t, TxOk, tx1.Prepare())
+	require.Equal(t, TxOk, tx1.Commit())
+	// tx1 has been committed, so tx2 is rollbacked
+	require.Equal(t, TxRollback, tx2.Prepare())
+	require.Equal(t, ErrTxFinished, tx2.Rollback())
+
+	var size int
+	doc = db.Document()
+	require.True(t, doc.Set(keyPath, expectedKey))
+
+	d, err := db.Get(doc)
+	doc.Free()
+	require.Nil(t, err)
+	require.False(t, d.IsEmpty())
+	require.Equal(t, expectedValue1, d.GetString(valuePath, &size))
+	d.Destroy()
+}
+
+func TestTxCursor(t *testing.T) {
+	const (
+		keyPath      = "key"
+		valuePath    = "value"
+		recordsCount = 10
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(keyPath, FieldTypeUInt64))
+	require.Nil(t, schema.AddValue(valuePath, FieldTypeUInt64))
+
+	db1, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database1",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+	db2, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database2",
+		Schema: schema,
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	set := func(ds interface{ Set(Document) error }, db *Database, key, value int64) {
+		doc := db.Document()
+		require.True(t, doc.SetInt(keyPath, key))
+		require.True(t, doc.SetInt(valuePath, value))
+		require.Nil(t, ds.Set(doc))
+		doc.Free()
+	}
+	scan := func(cursor *Cursor) [][2]int64 {
+		var res [][2]int64
+		for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+			res = append(res, [2]int64{d.GetInt(keyPath), d.GetInt(valuePath)})
+		}
+		require.Nil(t, cursor.Err())
+		return res
+	}
+	cursorDoc := func(db *Database, order Order, key int64) Document {
+		doc := db.Document()
+		require.True(t, doc.SetString(CursorOrder, string(order)))
+		if key >= 0 {
+			require.True(t, doc.SetInt(keyPath, key))
+		}
+		return doc
+	}
+
+	for i := int64(0); i < recordsCount; i++ {
+		set(db1, db1, i, i)
+	}
+
+	tx, err := env.BeginTx()
+	require.Nil(t, err)
+	set(tx, db1, 3, 33)
+	set(tx, db1, 20, 20)
+	set(tx, db1, 15, 15)
+	set(tx, db2, 100, 100)
+	doc := db1.Document()
+	require.True(t, doc.SetInt(keyPath, 5))
+	require.Nil(t, tx.Delete(doc))
+	doc.Free()
+	doc = db1.Document()
+	require.True(t, doc.SetInt(keyPath, 0))
+	require.Nil(t, tx.Delete(doc))
+	doc.Free()
+	// concurrent write isn't visible for the transaction
+	set(db1, db1, 7, 77)
+
+	expected := [][2]int64{{1, 1}, {2, 2}, {3, 33}, {4, 4}, {6, 6}, {7, 7}, {8, 8}, {9, 9}, {15, 15}, {20, 20}}
+
+	cursor, err := tx.Cursor(db1, db1.Document())
+	require.Nil(t, err)
+	require.Equal(t, expected, scan(cursor))
+	require.Nil(t, cursor.Close())
+
+	cursor, err = tx.Cursor(db1, cursorDoc(db1, LTE, -1))
+	require.Nil(t, err)
+	var reversed [][2]int64
+	for i := len(expected) - 1; i >= 0; i-- {
+		reversed = append(reversed, expected[i])
+	}
+	require.Equal(t, reversed, scan(cursor))
+	require.Nil(t, cursor.Close())
+
+	cursor, err = tx.Cursor(db1, cursorDoc(db1, GT, 3))
+	require.Nil(t, err)
+	require.Equal(t, expected[3:], scan(cursor))
+	require.Nil(t, cursor.Close())
+
+	cursor, err = tx.Cursor(db1, cursorDoc(db1, LT, 15))
+	require.Nil(t, err)
+	require.Equal(t, reversed[2:], scan(cursor))
+	require.Nil(t, cursor.Close())
+
+	cursor, err = tx.Cursor(db2, db2.Document())
+	require.Nil(t, err)
+	require.Equal(t, [][2]int64{{100, 100}}, scan(cursor))
+	require.Nil(t, cursor.Close())
+
+	doc = db1.Document()
+	_, err = tx.Cursor(db2, doc)
+	require.NotNil(t, err)
+	doc.Free()
+
+	// writes made while cursor is open are visible for it
+	cursor, err = tx.Cursor(db2, db2.Document())
+	require.Nil(t, err)
+	for i := int64(1000); i > 100; i-- {
+		set(tx, db2, i, i)
+	}
+	d := cursor.Next()
+	require.Equal(t, int64(100), d.GetInt(keyPath))
+	set(tx, db2, 50, 50)
+	set(tx, db2, 101, 0)
+	set(tx, db2, 2000, 2000)
+	res := scan(cursor)
+	require.Len(t, res, 901)
+	require.Equal(t, [2]int64{101, 0}, res[0])
+	require.Equal(t, [2]int64{1000, 1000}, res[899])
+	require.Equal(t, [2]int64{2000, 2000}, res[900])
+	require.Nil(t, cursor.Close())
+
+	// uncommitted changes aren't visible outside the transaction
+	cursor, err = db2.Cursor(db2.Document())
+	require.Nil(t, err)
+	require.Empty(t, scan(cursor))
+	require.Nil(t, cursor.Close())
+
+	// cursor is closed on commit
+	cursor, err = tx.Cursor(db1, db1.Document())
+	require.Nil(t, err)
+	d = cursor.Next()
+	require.False(t, d.IsEmpty())
+	require.Equal(t, TxOk, tx.Commit())
+	d = cursor.Next()
+	require.True(t, d.IsEmpty())
+	require.Equal(t, ErrCursorClosed, cursor.Close())
+
+	_, err = tx.Cursor(db1, db1.Document())
+	require.Equal(t, ErrTxFinished, err)
+
+	cursor, err = db1.Cursor(db1.Document())
+	require.Nil(t, err)
+	expected[5] = [2]int64{7, 77}
+	require.Equal(t, expected, scan(cursor))
+	require.Nil(t, cursor.Close())
+}
@@ -16,15 +16,16 @@ const (
 	FieldTypeString
 )
 
+// fieldTypeNames are names of types in sophia scheme, reversed types are named with '_rev' suffix
 var fieldTypeNames = map[FieldType]string{
 	FieldTypeUInt8:     "u8",
 	FieldTypeUInt16:    "u16",
 	FieldTypeUInt32:    "u32",
 	FieldTypeUInt64:    "u64",
-	FieldTypeUInt8Rev:  "u8rev",
-	FieldTypeUInt16Rev: "u16rev",
-	FieldTypeUInt32Rev: "u32rev",
-	FieldTypeUInt64Rev: "u64rev",
+	FieldTypeUInt8Rev:  "u8_rev",
+	FieldTypeUInt16Rev: "u16_rev",
+	FieldTypeUInt32Rev: "u32_rev",
+	FieldTypeUInt64Rev: "u64_rev",
 	FieldTypeString:    "string",
 }
 
@@ -36,6 +37,46 @@ func (t FieldType) String() string {
 	return name
 }
 
+// fieldTypeByName returns FieldType by it's sophia name
+func fieldTypeByName(name string) (FieldType, bool) {
+	for typ, typName := range fieldTypeNames {
+		if typName == name {
+			return typ, true
+		}
+	}
+	return 0, false
+}
+
+// direct returns not reversed type with the same representation
+func (t FieldType) direct() FieldType {
+	switch t {
+	case FieldTypeUInt8Rev:
+		return FieldTypeUInt8
+	case FieldTypeUInt16Rev:
+		return FieldTypeUInt16
+	case FieldTypeUInt32Rev:
+		return FieldTypeUInt32
+	case FieldTypeUInt64Rev:
+		return FieldTypeUInt64
+	}
+	return t
+}
+
+// size returns size of fixed size type in bytes, 0 for variable size types
+func (t FieldType) size() int {
+	switch t.direct() {
+	case FieldTypeUInt8:
+		return 1
+	case FieldTypeUInt16:
+		return 2
+	case FieldTypeUInt32:
+		return 4
+	case FieldTypeUInt64:
+		return 8
+	}
+	return 0
+}
+
 // CompressionType type of compression for content
 type CompressionType byte
 
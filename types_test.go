@@ -1,6 +1,9 @@
 package sophia
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -19,3 +22,54 @@ func TestUnknownCompressionType(t *testing.T) {
 		_ = typ.String()
 	})
 }
+
+func TestReversedFieldTypes(t *testing.T) {
+	const (
+		keyPath   = "key"
+		valuePath = "value"
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	types := []FieldType{FieldTypeUInt8Rev, FieldTypeUInt16Rev, FieldTypeUInt32Rev, FieldTypeUInt64Rev}
+	dbs := make([]*Database, len(types))
+	for i, typ := range types {
+		schema := &Schema{}
+		require.Nil(t, schema.AddKey(keyPath, typ))
+		require.Nil(t, schema.AddValue(valuePath, FieldTypeString))
+		dbs[i], err = env.NewDatabase(DatabaseConfig{
+			Name:   fmt.Sprintf("test_database_%v", typ),
+			Schema: schema,
+		})
+		require.Nil(t, err, "%v", typ)
+	}
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	for i, db := range dbs {
+		for _, key := range []int64{2, 1, 3} {
+			doc := db.Document()
+			require.True(t, doc.SetInt(keyPath, key))
+			require.True(t, doc.SetString(valuePath, "value"))
+			require.Nil(t, db.Set(doc))
+			doc.Free()
+		}
+
+		cursor, err := db.Cursor(db.Document())
+		require.Nil(t, err)
+		var keys []int64
+		for d := cursor.Next(); !d.IsEmpty(); d = cursor.Next() {
+			keys = append(keys, d.GetInt(keyPath))
+		}
+		require.Nil(t, cursor.Close())
+		require.Equal(t, []int64{3, 2, 1}, keys, "%v", types[i])
+	}
+}
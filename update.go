@@ -0,0 +1,147 @@
+package sophia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrTxRetriesExceeded is returned by Update when transaction has been
+	// rollbacked by concurrent transactions more times than RetryPolicy allows
+	ErrTxRetriesExceeded = errors.New("transaction rollbacked: retries limit reached")
+	// ErrTxLockTimeout is returned by Update when transaction has been waiting
+	// for concurrent transactions longer than RetryPolicy allows
+	ErrTxLockTimeout = errors.New("transaction lock timeout")
+)
+
+// RetryPolicy configures how Update handles TxLock and TxRollback statuses.
+type RetryPolicy struct {
+	// MaxRetries is a number of times the transaction function is rerun
+	// after transaction has been rollbacked by a concurrent one.
+	MaxRetries int
+	// Backoff is an initial delay between commit attempts of locked transaction.
+	// Delay is doubled after every attempt. Zero value means minRetryBackoff.
+	Backoff time.Duration
+	// MaxBackoff limits delay between commit attempts of locked transaction.
+	// Zero value means no limit.
+	MaxBackoff time.Duration
+	// LockTimeout limits total time of waiting for concurrent transactions.
+	// Zero value means no limit.
+	LockTimeout time.Duration
+}
+
+// DefaultRetryPolicy is used by Update unless another one is set by SetRetryPolicy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 10,
+	Backoff:    time.Millisecond,
+	MaxBackoff: 100 * time.Millisecond,
+}
+
+// minRetryBackoff is a delay between commit attempts of locked transaction used when Backoff isn't set,
+// so that retries don't spin
+const minRetryBackoff = 100 * time.Microsecond
+
+// SetRetryPolicy sets policy used by Update.
+// It can be called concurrently with Update.
+func (env *Environment) SetRetryPolicy(policy RetryPolicy) {
+	env.optionsMu.Lock()
+	env.retryPolicy = policy
+	env.optionsMu.Unlock()
+}
+
+func (env *Environment) loadRetryPolicy() RetryPolicy {
+	env.optionsMu.RLock()
+	defer env.optionsMu.RUnlock()
+	return env.retryPolicy
+}
+
+// Update executes fn within a transaction and commits it.
+// If fn returns an error, transaction is rollbacked and the error is returned.
+// Commit of locked transaction is retried with backoff, transaction rollbacked
+// by a concurrent one is started again and fn is rerun, as configured by RetryPolicy.
+// fn must not call Commit(), Prepare() or Rollback() of the transaction.
+// Transaction is always released when Update returns.
+func (env *Environment) Update(fn func(tx *Transaction) error) error {
+	return env.UpdateContext(context.Background(), fn)
+}
+
+// UpdateContext is like Update, but it stops retries and returns ctx error when ctx is done.
+// Transaction, which hasn't been committed, is rollbacked then.
+func (env *Environment) UpdateContext(ctx context.Context, fn func(tx *Transaction) error) error {
+	policy := env.loadRetryPolicy()
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		status, err := env.update(ctx, fn, policy)
+		if err != nil {
+			return err
+		}
+		if status == TxOk {
+			return nil
+		}
+		if attempt >= policy.MaxRetries {
+			return ErrTxRetriesExceeded
+		}
+	}
+}
+
+// update runs single attempt of Update and returns commit status
+func (env *Environment) update(ctx context.Context, fn func(tx *Transaction) error, policy RetryPolicy) (TxStatus, error) {
+	tx, err := env.BeginTx()
+	if err != nil {
+		return TxError, err
+	}
+	defer func() {
+		if !tx.finished {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return TxError, err
+	}
+	return env.commit(ctx, tx, policy)
+}
+
+// commit commits the transaction, commit of locked transaction is retried with backoff.
+// Transaction stays unfinished if lock timeout is reached or ctx is done,
+// status of the last commit attempt is returned then, it is TxError if there were no attempts.
+func (env *Environment) commit(ctx context.Context, tx *Transaction, policy RetryPolicy) (TxStatus, error) {
+	var deadline time.Time
+	if policy.LockTimeout > 0 {
+		deadline = time.Now().Add(policy.LockTimeout)
+	}
+	if err := ctx.Err(); err != nil {
+		return TxError, err
+	}
+	backoff := policy.Backoff
+	if backoff <= 0 {
+		backoff = minRetryBackoff
+	}
+	for {
+		switch status := tx.Commit(); status {
+		case TxOk, TxRollback:
+			return status, nil
+		case TxLock:
+		default:
+			return status, fmt.Errorf("failed to commit transaction: %v", env.Error())
+		}
+		if !deadline.IsZero() && time.Now().Add(backoff).After(deadline) {
+			return TxLock, ErrTxLockTimeout
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return TxLock, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package sophia
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentUpdate(t *testing.T) {
+	const (
+		keyPath       = "key"
+		valuePath     = "value"
+		expectedKey   = "key1"
+		expectedValue = "value1"
+	)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name: "test_database",
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	set := func(tx *Transaction, value string) {
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, expectedKey))
+		require.True(t, doc.Set(valuePath, value))
+		require.Nil(t, tx.Set(doc))
+		doc.Free()
+	}
+	get := func() string {
+		doc := db.Document()
+		require.True(t, doc.Set(keyPath, expectedKey))
+		d, err := db.Get(doc)
+		doc.Free()
+		if err == ErrNotFound {
+			return ""
+		}
+		require.Nil(t, err)
+		defer d.Destroy()
+		var size int
+		return d.GetString(valuePath, &size)
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		require.Nil(t, env.Update(func(tx *Transaction) error {
+			set(tx, expectedValue)
+			return nil
+		}))
+		require.Equal(t, expectedValue, get())
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		expectedErr := errors.New("update error")
+		err := env.Update(func(tx *Transaction) error {
+			set(tx, "failed")
+			return expectedErr
+		})
+		require.Equal(t, expectedErr, err)
+		require.Equal(t, expectedValue, get())
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		var attempts int
+		err := env.Update(func(tx *Transaction) error {
+			attempts++
+			if attempts == 1 {
+				concurrent, err := env.BeginTx()
+				require.Nil(t, err)
+				set(concurrent, "concurrent")
+				set(tx, "value2")
+				require.Equal(t, TxOk, concurrent.Commit())
+				return nil
+			}
+			set(tx, "value2")
+			return nil
+		})
+		require.Nil(t, err)
+		require.Equal(t, 2, attempts)
+		require.Equal(t, "value2", get())
+	})
+
+	t.Run("Retries limit", func(t *testing.T) {
+		env.SetRetryPolicy(RetryPolicy{MaxRetries: 2})
+		defer env.SetRetryPolicy(DefaultRetryPolicy)
+
+		var attempts int
+		err := env.Update(func(tx *Transaction) error {
+			attempts++
+			concurrent, err := env.BeginTx()
+			require.Nil(t, err)
+			set(concurrent, "concurrent")
+			set(tx, "value3")
+			require.Equal(t, TxOk, concurrent.Commit())
+			return nil
+		})
+		require.Equal(t, ErrTxRetriesExceeded, err)
+		require.Equal(t, 3, attempts)
+		require.Equal(t, "concurrent", get())
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		var attempts int
+		err := env.Update(func(tx *Transaction) error {
+			attempts++
+			concurrent, err := env.BeginTx()
+			require.Nil(t, err)
+			set(concurrent, "concurrent")
+			set(tx, "value4")
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				concurrent.Rollback()
+			}()
+			return nil
+		})
+		require.Nil(t, err)
+		require.Equal(t, 1, attempts)
+		require.Equal(t, "value4", get())
+	})
+
+	t.Run("Lock without backoff", func(t *testing.T) {
+		// policy is changed concurrently with Update
+		done := make(chan struct{})
+		go func() {
+			env.SetRetryPolicy(RetryPolicy{MaxRetries: 2})
+			close(done)
+		}()
+		defer env.SetRetryPolicy(DefaultRetryPolicy)
+
+		err := env.Update(func(tx *Transaction) error {
+			concurrent, err := env.BeginTx()
+			require.Nil(t, err)
+			set(concurrent, "concurrent")
+			set(tx, "value4")
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				concurrent.Rollback()
+			}()
+			return nil
+		})
+		<-done
+		require.Nil(t, err)
+		require.Equal(t, "value4", get())
+	})
+
+	t.Run("Lock timeout", func(t *testing.T) {
+		env.SetRetryPolicy(RetryPolicy{
+			Backoff:     time.Millisecond,
+			LockTimeout: 20 * time.Millisecond,
+		})
+		defer env.SetRetryPolicy(DefaultRetryPolicy)
+
+		var concurrent *Transaction
+		err := env.Update(func(tx *Transaction) error {
+			concurrent, err = env.BeginTx()
+			require.Nil(t, err)
+			set(concurrent, "concurrent")
+			set(tx, "value5")
+			return nil
+		})
+		require.Equal(t, ErrTxLockTimeout, err)
+		require.Nil(t, concurrent.Rollback())
+		require.Equal(t, "value4", get())
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		env.SetRetryPolicy(RetryPolicy{Backoff: time.Millisecond})
+		defer env.SetRetryPolicy(DefaultRetryPolicy)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		var concurrent *Transaction
+		err := env.UpdateContext(ctx, func(tx *Transaction) error {
+			concurrent, err = env.BeginTx()
+			require.Nil(t, err)
+			set(concurrent, "concurrent")
+			set(tx, "value6")
+			return nil
+		})
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		require.Nil(t, concurrent.Rollback())
+		require.Equal(t, "value4", get())
+
+		err = env.UpdateContext(ctx, func(tx *Transaction) error {
+			t.Fatal("update is called with done context")
+			return nil
+		})
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("CommitContext", func(t *testing.T) {
+		env.SetRetryPolicy(RetryPolicy{Backoff: time.Millisecond})
+		defer env.SetRetryPolicy(DefaultRetryPolicy)
+
+		tx, err := env.BeginTx()
+		require.Nil(t, err)
+		concurrent, err := env.BeginTx()
+		require.Nil(t, err)
+		set(concurrent, "concurrent")
+		set(tx, "value7")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		status, err := tx.CommitContext(ctx)
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		require.Equal(t, TxLock, status)
+
+		require.Nil(t, concurrent.Rollback())
+		status, err = tx.CommitContext(context.Background())
+		require.Nil(t, err)
+		require.Equal(t, TxOk, status)
+		require.Equal(t, "value7", get())
+
+		status, err = tx.CommitContext(context.Background())
+		require.Equal(t, ErrTxFinished, err)
+		require.Equal(t, TxError, status)
+	})
+}
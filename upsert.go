@@ -1,7 +1,10 @@
 package sophia
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"runtime/cgo"
 	"sync"
 	"unsafe"
 )
@@ -9,7 +12,12 @@ import (
 /*
 #include <inttypes.h>
 #include <stdio.h>
-extern void goUpsertCall(int count,
+extern int goUpsertCall(int count,
+		char **src,    uint32_t *src_size,
+		char **upsert, uint32_t *upsert_size,
+		char **result, uint32_t *result_size,
+		void *arg);
+extern int goUpsertHandlerCall(int count,
 		char **src,    uint32_t *src_size,
 		char **upsert, uint32_t *upsert_size,
 		char **result, uint32_t *result_size,
@@ -23,124 +31,211 @@ const (
 	keyUpsertArgTemplate = "db.%v.upsert_arg"
 )
 
-// upsertFunc golang binding to upsert_callback.
-// It is a wrapper for UpsertFunc, that converts C types to golang ones
-type upsertFunc func(count C.int,
-	src **C.char, srcSize *C.uint32_t,
-	upsert **C.char, upsertSize *C.uint32_t,
-	result **C.char, resultSize *C.uint32_t,
-	arg unsafe.Pointer) C.int
+// ErrUpsertDiscarded is returned by Database.Upsert, if an earlier upsert of the database
+// has been discarded because of failure of the upsert callback
+var ErrUpsertDiscarded = errors.New("earlier upsert has been discarded")
 
 // UpsertFunc golang equivalent of upsert_callback.
 // Should return 0 in case of success, otherwise -1.
+// If it returns -1 or panics, the upsert is discarded: the existing document is kept
+// or, if there is no existing document, the upsert is replaced by deletion of the key,
+// so the document isn't created and reads don't fail.
+// The failure is reported to DatabaseConfig.OnCallbackError and by Database.UpsertErr.
 type UpsertFunc func(count int,
 	src []unsafe.Pointer, srcSize []uint32,
 	upsert []unsafe.Pointer, upsertSize []uint32,
 	result []unsafe.Pointer, resultSize []uint32,
 	arg unsafe.Pointer) int
 
-//export goUpsertCall
-func goUpsertCall(count C.int,
-	src **C.char, src_size *C.uint32_t,
-	upsert **C.char, upsert_size *C.uint32_t,
-	result **C.char, result_size *C.uint32_t,
-	arg unsafe.Pointer) {
-
-	index := (*int)(arg)
-	fn := getUpsert(index)
-	upsertArg := getUpsertArg(index)
-	fn(count, src, src_size, upsert, upsert_size, result, result_size, upsertArg)
+// UpsertHandler is a safe alternative to UpsertFunc.
+// It receives the existing row, which is empty if there is no document with the same key,
+// and the upserted row, and returns the row which will be stored.
+// Any of the received rows can be modified and returned, except key fields, setting of them returns ErrKeyField.
+//
+// Sophia applies upserts lazily, during reads and compaction, so the error can't be returned
+// by the Upsert call, which has written the upsert. Until upserts are merged by compaction,
+// every read applies them again, so the handler can be called and fail for the same upsert several times.
+// If the handler returns an error or panics, the upsert is discarded: the existing row is kept
+// or, if there is no existing row, the upsert is replaced by deletion of the key.
+// The error is reported to DatabaseConfig.OnCallbackError, it is returned by Database.UpsertErr
+// or by the next Database.Upsert.
+type UpsertHandler func(old, delta Row) (Row, error)
+
+// upsertErrors keeps the first error of upsert callbacks of a database until it is retrieved
+type upsertErrors struct {
+	mu  sync.Mutex
+	err error
 }
 
-var upsertMap = make(map[*int]upsertFunc)
-var upsertMu sync.RWMutex
-var upsertIndex int
-
-var upsertArgMap = make(map[*int]unsafe.Pointer)
-var upsertArgMu sync.RWMutex
+// Upsert applies doc to the document with the same key using database upsert callback.
+// If an earlier upsert has been discarded, doc is written anyway, and error wrapping
+// ErrUpsertDiscarded and the error of the callback is returned, see UpsertErr.
+func (db *Database) Upsert(doc Document) error {
+	if err := db.dataStore.Upsert(doc); err != nil {
+		return err
+	}
+	if err := db.UpsertErr(); err != nil {
+		return fmt.Errorf("%w: %w", ErrUpsertDiscarded, err)
+	}
+	return nil
+}
 
-func getUpsertArg(index *int) unsafe.Pointer {
-	upsertArgMu.RLock()
-	defer upsertArgMu.RUnlock()
-	return upsertArgMap[index]
+// UpsertErr returns the first error of upsert callbacks of the database since the error
+// has been returned last time by UpsertErr or Upsert, and resets it.
+// Callbacks are called by reads and compaction, so failed upserts are detected after they have been written.
+func (db *Database) UpsertErr() error {
+	db.upsertErrors.mu.Lock()
+	defer db.upsertErrors.mu.Unlock()
+	err := db.upsertErrors.err
+	db.upsertErrors.err = nil
+	return err
 }
 
-func registerUpsertArg(index *int, arg interface{}) {
-	upsertArgMu.Lock()
-	defer upsertArgMu.Unlock()
-	if arg == nil {
-		return
-	}
-	val := reflect.ValueOf(arg)
-	if val.CanAddr() {
-		upsertArgMap[index] = unsafe.Pointer(val.Pointer())
-		return
+// upsertFailed saves error of upsert callback until it's retrieved and reports it
+func (db *Database) upsertFailed(err error) {
+	db.upsertErrors.mu.Lock()
+	if db.upsertErrors.err == nil {
+		db.upsertErrors.err = err
 	}
+	db.upsertErrors.mu.Unlock()
+	db.reportCallbackError(err)
+}
 
-	switch val.Kind() {
-	case reflect.String:
-		str := val.String()
-		upsertArgMap[index] = unsafe.Pointer(&str)
-	case reflect.Int, reflect.Int64, reflect.Int8, reflect.Int16, reflect.Int32:
-		i := val.Int()
-		upsertArgMap[index] = unsafe.Pointer(&i)
-	case reflect.Uint, reflect.Uint64, reflect.Uint8, reflect.Uint16, reflect.Uint32:
-		i := val.Uint()
-		upsertArgMap[index] = unsafe.Pointer(&i)
-	}
+// upsertBinding binds UpsertFunc or UpsertHandler to the database.
+// It is passed to sophia as upsert_arg and lives until the environment is closed.
+type upsertBinding struct {
+	db      *Database
+	fn      UpsertFunc
+	arg     unsafe.Pointer
+	handler UpsertHandler
 }
 
-func registerUpsert(upsertFunc UpsertFunc) (unsafe.Pointer, *int) {
-	upsertMu.Lock()
-	defer upsertMu.Unlock()
+//export goUpsertCall
+func goUpsertCall(count C.int,
+	src **C.char, src_size *C.uint32_t,
+	upsert **C.char, upsert_size *C.uint32_t,
+	result **C.char, result_size *C.uint32_t,
+	arg unsafe.Pointer) (rc C.int) {
 
-	index := upsertIndex
-	upsertIndex++
-	indexPtr := &index
+	cb := cgo.Handle(uintptr(arg)).Value().(*upsertBinding)
+	defer cb.recover(&rc)
 
-	upsertMap[indexPtr] = func(count C.int,
-		srcC **C.char, srcSizesC *C.uint32_t,
-		upsertC **C.char, upsertSizesC *C.uint32_t,
-		resultC **C.char, resultSizesC *C.uint32_t,
-		arg unsafe.Pointer) C.int {
+	n := int(count)
+	var srcs []unsafe.Pointer
+	var srcSizes []uint32
+	if src != nil {
+		srcs = unsafe.Slice((*unsafe.Pointer)(unsafe.Pointer(src)), n)
+		srcSizes = unsafe.Slice((*uint32)(unsafe.Pointer(src_size)), n)
+	}
+	res := cb.fn(n,
+		srcs, srcSizes,
+		unsafe.Slice((*unsafe.Pointer)(unsafe.Pointer(upsert)), n),
+		unsafe.Slice((*uint32)(unsafe.Pointer(upsert_size)), n),
+		unsafe.Slice((*unsafe.Pointer)(unsafe.Pointer(result)), n),
+		unsafe.Slice((*uint32)(unsafe.Pointer(result_size)), n),
+		cb.arg)
+	if res != 0 {
+		cb.db.upsertFailed(fmt.Errorf("upsert callback returned %v", res))
+		return -1
+	}
+	return 0
+}
 
-		countN := int(count)
+//export goUpsertHandlerCall
+func goUpsertHandlerCall(count C.int,
+	src **C.char, src_size *C.uint32_t,
+	upsert **C.char, upsert_size *C.uint32_t,
+	result **C.char, result_size *C.uint32_t,
+	arg unsafe.Pointer) (rc C.int) {
 
-		// We receive C pointer to pointer which can be interpreted as an array of pointers.
-		// Here we cast C pointer to pointer to Go slice of pointers.
-		upsertSizes := (*[16]uint32)(unsafe.Pointer(upsertSizesC))[:countN]
-		resultSizes := (*[16]uint32)(unsafe.Pointer(resultSizesC))[:countN]
+	cb := cgo.Handle(uintptr(arg)).Value().(*upsertBinding)
+	defer cb.recover(&rc)
 
-		upsert := (*[16]unsafe.Pointer)(unsafe.Pointer(upsertC))[:countN]
-		result := (*[16]unsafe.Pointer)(unsafe.Pointer(resultC))[:countN]
+	n := int(count)
+	var old Row
+	if src != nil {
+		old = cb.row(unsafe.Slice(src, n), unsafe.Slice(src_size, n))
+	}
+	res, err := cb.handler(old, cb.row(unsafe.Slice(upsert, n), unsafe.Slice(upsert_size, n)))
+	if err == nil && (res.db != cb.db || res.IsEmpty()) {
+		err = errors.New("upsert handler returned an empty row or a row of another database")
+	}
+	if err != nil {
+		cb.db.upsertFailed(fmt.Errorf("upsert handler: %w", err))
+		return -1
+	}
 
-		var src []unsafe.Pointer
-		var srcSizes []uint32
-		if srcC != nil {
-			srcSizes = (*[16]uint32)(unsafe.Pointer(srcSizesC))[:countN]
-			src = (*[16]unsafe.Pointer)(unsafe.Pointer(srcC))[:countN]
+	// Sophia frees result fields, which differ from fields of the existing row
+	// or of the upserted one, if there is no existing row. So changed values are copied to C memory.
+	results := unsafe.Slice(result, n)
+	resultSizes := unsafe.Slice(result_size, n)
+	for i, value := range res.fields {
+		if len(value) == int(resultSizes[i]) &&
+			(len(value) == 0 || unsafe.Pointer(&value[0]) == unsafe.Pointer(results[i])) {
+			continue
 		}
+		results[i] = (*C.char)(cBytes(value))
+		resultSizes[i] = C.uint32_t(len(value))
+	}
+	return 0
+}
 
-		res := upsertFunc(countN,
-			src, srcSizes,
-			upsert, upsertSizes,
-			result, resultSizes,
-			arg)
+// recover reports panic of upsert callback as the database callback error.
+// Panic can't be propagated through C code, so sophia gets -1 instead.
+func (cb *upsertBinding) recover(rc *C.int) {
+	if r := recover(); r != nil {
+		cb.db.upsertFailed(fmt.Errorf("upsert callback panic: %v", r))
+		*rc = -1
+	}
+}
 
-		return C.int(res)
+// row creates a Row from fields passed by sophia, fields values aren't copied
+func (cb *upsertBinding) row(fields []*C.char, sizes []C.uint32_t) Row {
+	row := Row{
+		db:        cb.db,
+		fields:    make([][]byte, cb.db.fieldsCount),
+		keysFixed: true,
 	}
-	ptr := C.goUpsertCall
-	return ptr, indexPtr
+	for i := range row.fields {
+		row.fields[i] = unsafe.Slice((*byte)(unsafe.Pointer(fields[i])), int(sizes[i]))
+	}
+	return row
 }
 
-func getUpsert(index *int) upsertFunc {
-	upsertMu.RLock()
-	defer upsertMu.RUnlock()
-	return upsertMap[index]
+// setUpsert registers upsert callback of database.
+// Callback is released when the environment is closed.
+func (env *Environment) setUpsert(name string, cb *upsertBinding) error {
+	fn := unsafe.Pointer(C.goUpsertCall)
+	if cb.handler != nil {
+		fn = unsafe.Pointer(C.goUpsertHandlerCall)
+	}
+	arg := handlePointer(env.newHandle(cb))
+	if !env.Set(fmt.Sprintf(keyUpsertTemplate, name), fn) ||
+		!env.Set(fmt.Sprintf(keyUpsertArgTemplate, name), arg) {
+		return fmt.Errorf("failed to set upsert: %v", env.Error())
+	}
+	return nil
 }
 
-func unregisterUpsert(index *int) {
-	upsertMu.Lock()
-	defer upsertMu.Unlock()
-	delete(upsertMap, index)
+// upsertArgPointer converts UpsertArg to pointer, which is passed to UpsertFunc.
+// Pointers are passed as is, strings and integers are passed as pointers to their copies.
+func upsertArgPointer(arg interface{}) unsafe.Pointer {
+	if arg == nil {
+		return nil
+	}
+	val := reflect.ValueOf(arg)
+	switch val.Kind() {
+	case reflect.Ptr, reflect.UnsafePointer:
+		return unsafe.Pointer(val.Pointer())
+	case reflect.String:
+		str := val.String()
+		return unsafe.Pointer(&str)
+	case reflect.Int, reflect.Int64, reflect.Int8, reflect.Int16, reflect.Int32:
+		i := val.Int()
+		return unsafe.Pointer(&i)
+	case reflect.Uint, reflect.Uint64, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		i := val.Uint()
+		return unsafe.Pointer(&i)
+	}
+	return nil
 }
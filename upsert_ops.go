@@ -0,0 +1,146 @@
+package sophia
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// UpsertOp is a built-in operation, which merges value of upserted field into the existing one
+type UpsertOp byte
+
+// UpsertOp constants for built-in upsert operations
+const (
+	// Add adds upserted value to the existing one, overflow wraps around
+	Add UpsertOp = iota + 1
+	// Max keeps the greatest of values, strings are compared bytewise
+	Max
+	// Min keeps the least of values, strings are compared bytewise
+	Min
+	// Append appends upserted value to the existing string
+	Append
+)
+
+var upsertOpNames = map[UpsertOp]string{
+	Add:    "add",
+	Max:    "max",
+	Min:    "min",
+	Append: "append",
+}
+
+func (op UpsertOp) String() string {
+	name, ok := upsertOpNames[op]
+	if !ok {
+		panic("illegal upsert operation")
+	}
+	return name
+}
+
+// UpsertOps describes upsert as a set of operations per value field.
+// Fields, which have no operation, are replaced by upserted values.
+// If there is no existing document, the upserted one is stored as is.
+type UpsertOps map[string]UpsertOp
+
+// validate checks that operations are applicable to schema fields
+func (ops UpsertOps) validate(schema *Schema) error {
+	for name, op := range ops {
+		if _, ok := upsertOpNames[op]; !ok {
+			return fmt.Errorf("illegal upsert operation %v for field '%v'", byte(op), name)
+		}
+		if _, ok := schema.keys[name]; ok {
+			return fmt.Errorf("upsert operation %v can't be applied to key field '%v'", op, name)
+		}
+		typ, ok := schema.values[name]
+		if !ok {
+			return fmt.Errorf("%w: upsert operation %v for '%v'", ErrUnknownField, op, name)
+		}
+		// Max and Min are applicable to fields of any type
+		if (op == Append && typ != FieldTypeString) || (op == Add && typ == FieldTypeString) {
+			return fmt.Errorf("%w: upsert operation %v can't be applied to field '%v' of type %v",
+				ErrFieldType, op, name, typ)
+		}
+	}
+	return nil
+}
+
+// handler returns UpsertHandler which applies operations, ops must be validated against the database schema
+func (ops UpsertOps) handler() UpsertHandler {
+	return func(old, delta Row) (Row, error) {
+		if old.IsEmpty() {
+			return delta, nil
+		}
+		for name, op := range ops {
+			typ, ok := delta.db.schema.values[name]
+			if !ok {
+				return Row{}, fmt.Errorf("%w: '%v'", ErrUnknownField, name)
+			}
+			pos := delta.db.positions[name]
+			delta.fields[pos] = op.apply(typ, old.fields[pos], delta.fields[pos])
+		}
+		return delta, nil
+	}
+}
+
+// apply returns result of operation for values of field of type typ
+func (op UpsertOp) apply(typ FieldType, old, delta []byte) []byte {
+	switch op {
+	case Append:
+		return append(append(make([]byte, 0, len(old)+len(delta)), old...), delta...)
+	case Max:
+		if compareValues(typ, old, delta) > 0 {
+			return old
+		}
+		return delta
+	case Min:
+		if compareValues(typ, old, delta) < 0 {
+			return old
+		}
+		return delta
+	case Add:
+		return putUint(typ.size(), getUint(old)+getUint(delta))
+	}
+	return delta
+}
+
+// compareValues compares values of fixed size fields as numbers and strings bytewise
+func compareValues(typ FieldType, a, b []byte) int {
+	if typ == FieldTypeString {
+		return bytes.Compare(a, b)
+	}
+	x, y := getUint(a), getUint(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// getUint decodes value of fixed size field
+func getUint(b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(binary.NativeEndian.Uint16(b))
+	case 4:
+		return uint64(binary.NativeEndian.Uint32(b))
+	case 8:
+		return binary.NativeEndian.Uint64(b)
+	}
+	return 0
+}
+
+// putUint encodes value of fixed size field
+func putUint(size int, val uint64) []byte {
+	switch size {
+	case 1:
+		return []byte{byte(val)}
+	case 2:
+		return binary.NativeEndian.AppendUint16(nil, uint16(val))
+	case 4:
+		return binary.NativeEndian.AppendUint32(nil, uint32(val))
+	}
+	return binary.NativeEndian.AppendUint64(nil, val)
+}
@@ -1,9 +1,13 @@
 package sophia
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
+	"sync"
 	"testing"
 	"unsafe"
 
@@ -282,3 +286,341 @@ func TestDatabaseUpsertArguments(t *testing.T) {
 		require.EqualValues(t, expected.value, result.GetInt(valuePath))
 	}
 }
+
+func TestDatabaseUpsertHandler(t *testing.T) {
+	const (
+		keyPath    = "key"
+		hitsPath   = "hits"
+		namePath   = "name"
+		extraCount = 20
+	)
+	errZeroHits := errors.New("zero hits")
+	keyErrors := make(chan error, 1)
+	handlerErrors := make(chan error, 1)
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey(keyPath, FieldTypeUInt32))
+	require.Nil(t, schema.AddValue(hitsPath, FieldTypeUInt64))
+	require.Nil(t, schema.AddValue(namePath, FieldTypeString))
+	for i := 0; i < extraCount; i++ {
+		require.Nil(t, schema.AddValue(fmt.Sprintf("extra%v", i), FieldTypeUInt32))
+	}
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Schema: schema,
+		UpsertHandler: func(old, delta Row) (Row, error) {
+			if old.IsEmpty() {
+				return delta, nil
+			}
+			select {
+			case keyErrors <- old.SetUint32(keyPath, 2):
+			default:
+			}
+			hits, err := delta.GetUint64(hitsPath)
+			if err != nil {
+				return Row{}, err
+			}
+			if hits == 0 {
+				return Row{}, errZeroHits
+			}
+			oldHits, err := old.GetUint64(hitsPath)
+			if err != nil {
+				return Row{}, err
+			}
+			oldName, err := old.GetBytes(namePath)
+			if err != nil {
+				return Row{}, err
+			}
+			name, err := delta.GetBytes(namePath)
+			if err != nil {
+				return Row{}, err
+			}
+			if err := old.SetUint64(hitsPath, oldHits+hits); err != nil {
+				return Row{}, err
+			}
+			return old, old.SetBytes(namePath, append(oldName, name...))
+		},
+		OnCallbackError: func(err error) {
+			select {
+			case handlerErrors <- err:
+			default:
+			}
+		},
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	upsert := func(hits uint64, name string) error {
+		doc := db.Document()
+		defer doc.Free()
+		require.Nil(t, doc.SetUint32(keyPath, 1))
+		require.Nil(t, doc.SetUint64(hitsPath, hits))
+		require.Nil(t, doc.SetBytes(namePath, []byte(name)))
+		for i := 0; i < extraCount; i++ {
+			require.Nil(t, doc.SetUint32(fmt.Sprintf("extra%v", i), uint32(i)))
+		}
+		return db.Upsert(doc)
+	}
+	check := func(expectedHits uint64, expectedName string) {
+		doc := db.Document()
+		require.Nil(t, doc.SetUint32(keyPath, 1))
+		d, err := db.Get(doc)
+		doc.Free()
+		require.Nil(t, err)
+		defer d.Destroy()
+		hits, err := d.GetUint64(hitsPath)
+		require.Nil(t, err)
+		require.Equal(t, expectedHits, hits)
+		name, err := d.GetBytes(namePath)
+		require.Nil(t, err)
+		require.Equal(t, expectedName, string(name))
+		extra, err := d.GetUint32(fmt.Sprintf("extra%v", extraCount-1))
+		require.Nil(t, err)
+		require.Equal(t, uint32(extraCount-1), extra)
+	}
+
+	require.Nil(t, upsert(1, "a"))
+	require.Nil(t, upsert(2, "b"))
+	require.Nil(t, upsert(3, "c"))
+	check(6, "abc")
+
+	require.Nil(t, upsert(0, "d"))
+	check(6, "abc")
+	// error of the discarded upsert is returned by the next one, which is written anyway
+	err = upsert(1, "e")
+	require.ErrorIs(t, err, ErrUpsertDiscarded)
+	require.ErrorIs(t, err, errZeroHits)
+	require.Nil(t, upsert(1, "f"))
+	check(8, "abcef")
+	// every read applies the discarded upsert again until compaction
+	require.ErrorIs(t, db.UpsertErr(), errZeroHits)
+	require.Nil(t, db.UpsertErr())
+	require.ErrorIs(t, <-handlerErrors, errZeroHits)
+	require.ErrorIs(t, <-keyErrors, ErrKeyField)
+}
+
+func TestDatabaseUpsertOps(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey("key", FieldTypeString))
+	require.Nil(t, schema.AddValue("hits", FieldTypeUInt64))
+	require.Nil(t, schema.AddValue("last", FieldTypeUInt32))
+	require.Nil(t, schema.AddValue("first", FieldTypeUInt16Rev))
+	require.Nil(t, schema.AddValue("history", FieldTypeString))
+	require.Nil(t, schema.AddValue("name", FieldTypeString))
+	require.Nil(t, schema.AddValue("max_name", FieldTypeString))
+	require.Nil(t, schema.AddValue("min_name", FieldTypeString))
+
+	for _, ops := range []UpsertOps{
+		{"key": Append},
+		{"unknown": Add},
+		{"hits": Append},
+		{"history": Add},
+		{"hits": 0},
+	} {
+		_, err := env.NewDatabase(DatabaseConfig{
+			Name:      "illegal",
+			Schema:    schema,
+			UpsertOps: ops,
+		})
+		require.Error(t, err, "%v", ops)
+	}
+
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Schema: schema,
+		UpsertOps: UpsertOps{
+			"hits":     Add,
+			"last":     Max,
+			"first":    Min,
+			"history":  Append,
+			"max_name": Max,
+			"min_name": Min,
+		},
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+	defer env.Close()
+
+	for _, ts := range []uint64{20, 10, 30} {
+		doc := db.Document()
+		require.Nil(t, doc.SetBytes("key", []byte("key")))
+		require.Nil(t, doc.SetUint64("hits", 1))
+		require.Nil(t, doc.SetUint32("last", uint32(ts)))
+		require.Nil(t, doc.SetUint16("first", uint16(ts)))
+		require.Nil(t, doc.SetBytes("history", []byte(fmt.Sprint(ts, ";"))))
+		require.Nil(t, doc.SetBytes("name", []byte(fmt.Sprint("name", ts))))
+		require.Nil(t, doc.SetBytes("max_name", []byte(fmt.Sprint("name", ts))))
+		require.Nil(t, doc.SetBytes("min_name", []byte(fmt.Sprint("name", ts))))
+		require.Nil(t, db.Upsert(doc))
+		doc.Free()
+	}
+
+	doc := db.Document()
+	require.Nil(t, doc.SetBytes("key", []byte("key")))
+	d, err := db.Get(doc)
+	doc.Free()
+	require.Nil(t, err)
+	defer d.Destroy()
+
+	hits, err := d.GetUint64("hits")
+	require.Nil(t, err)
+	require.Equal(t, uint64(3), hits)
+	last, err := d.GetUint32("last")
+	require.Nil(t, err)
+	require.Equal(t, uint32(30), last)
+	first, err := d.GetUint16("first")
+	require.Nil(t, err)
+	require.Equal(t, uint16(10), first)
+	history, err := d.GetBytes("history")
+	require.Nil(t, err)
+	require.Equal(t, "20;10;30;", string(history))
+	name, err := d.GetBytes("name")
+	require.Nil(t, err)
+	require.Equal(t, "name30", string(name))
+	maxName, err := d.GetBytes("max_name")
+	require.Nil(t, err)
+	require.Equal(t, "name30", string(maxName))
+	minName, err := d.GetBytes("min_name")
+	require.Nil(t, err)
+	require.Equal(t, "name10", string(minName))
+}
+
+func TestDatabaseUpsertPanic(t *testing.T) {
+	const keysCount = 10
+	tmpDir, err := ioutil.TempDir("", "sophia_test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnvironment()
+	require.Nil(t, err)
+	require.NotNil(t, env)
+
+	require.True(t, env.SetString(EnvironmentPath, tmpDir))
+	require.Nil(t, env.SetSchedulerConfig(SchedulerConfig{Manual: true}))
+
+	schema := &Schema{}
+	require.Nil(t, schema.AddKey("key", FieldTypeUInt32))
+	require.Nil(t, schema.AddValue("id", FieldTypeUInt32))
+
+	var (
+		mu       sync.Mutex
+		reported []error
+	)
+	db, err := env.NewDatabase(DatabaseConfig{
+		Name:   "test_database",
+		Schema: schema,
+		Upsert: func(count int,
+			src []unsafe.Pointer, srcSize []uint32,
+			upsert []unsafe.Pointer, upsertSize []uint32,
+			result []unsafe.Pointer, resultSize []uint32,
+			arg unsafe.Pointer) int {
+			switch *(*uint32)(upsert[1]) {
+			case 0:
+				panic("zero increment")
+			case math.MaxUint32:
+				return -1
+			}
+			return upsertCallback(count, src, srcSize, upsert, upsertSize, result, resultSize, arg)
+		},
+		OnCallbackError: func(err error) {
+			mu.Lock()
+			reported = append(reported, err)
+			mu.Unlock()
+		},
+	})
+	require.Nil(t, err)
+	require.NotNil(t, db)
+
+	require.Nil(t, env.Open())
+
+	upsert := func(key, increment uint32) error {
+		doc := db.Document()
+		defer doc.Free()
+		require.Nil(t, doc.SetUint32("key", key))
+		require.Nil(t, doc.SetUint32("id", increment))
+		return db.Upsert(doc)
+	}
+	for key := uint32(0); key < keysCount; key++ {
+		require.Nil(t, upsert(key, 1))
+		require.Nil(t, upsert(key, 2))
+	}
+	require.Nil(t, upsert(keysCount/2, 0))
+	require.Nil(t, upsert(keysCount/2+1, math.MaxUint32))
+	// failed first upserts don't create documents
+	require.Nil(t, upsert(keysCount, 0))
+	require.Nil(t, upsert(keysCount+1, math.MaxUint32))
+	require.Nil(t, upsert(keysCount+2, 0))
+	require.Nil(t, upsert(keysCount+2, 3))
+	require.Nil(t, db.UpsertErr())
+	doc := db.Document()
+	require.Nil(t, doc.SetUint32("key", keysCount))
+	_, err = db.Get(doc)
+	doc.Free()
+	require.ErrorIs(t, err, ErrNotFound)
+	require.EqualError(t, db.UpsertErr(), "upsert callback panic: zero increment")
+	require.Nil(t, env.Compact(context.Background()))
+
+	mu.Lock()
+	messages := make(map[string]bool)
+	for _, err := range reported {
+		messages[err.Error()] = true
+	}
+	mu.Unlock()
+	require.Equal(t, map[string]bool{
+		"upsert callback panic: zero increment": true,
+		"upsert callback returned -1":           true,
+	}, messages)
+	// the first error since the previous retrieval is returned by the next upsert
+	err = upsert(0, 1)
+	require.ErrorIs(t, err, ErrUpsertDiscarded)
+	require.Nil(t, db.UpsertErr())
+
+	for key := uint32(0); key < keysCount+3; key++ {
+		doc := db.Document()
+		require.Nil(t, doc.SetUint32("key", key))
+		d, err := db.Get(doc)
+		doc.Free()
+		if key == keysCount || key == keysCount+1 {
+			require.ErrorIs(t, err, ErrNotFound, "key %v", key)
+			continue
+		}
+		require.Nil(t, err, "key %v", key)
+		id, err := d.GetUint32("id")
+		require.Nil(t, err)
+		if key == 0 {
+			require.Equal(t, uint32(4), id)
+		} else {
+			require.Equal(t, uint32(3), id, "key %v", key)
+		}
+		require.Nil(t, d.Destroy())
+	}
+
+	require.NotEmpty(t, env.handles)
+	require.Nil(t, env.Close())
+	require.Empty(t, env.handles)
+}
@@ -2,6 +2,7 @@ package sophia
 
 import (
 	"reflect"
+	"runtime"
 	"unsafe"
 )
 
@@ -15,6 +16,13 @@ type varStore struct {
 	// pointers slice of pointers to allocated C variables,
 	// that must be freed after store usage
 	pointers []unsafe.Pointer
+
+	// pinner pins Go memory of values, which are set without copying,
+	// they are unpinned after store usage
+	pinner *runtime.Pinner
+
+	// live tracks lifetime of C object in debug mode
+	live *liveObject
 }
 
 func newVarStore(ptr unsafe.Pointer, size int) varStore {
@@ -31,6 +39,7 @@ func (s *varStore) IsEmpty() bool {
 
 // TODO :: implement custom types
 func (s *varStore) Set(path string, val interface{}) bool {
+	s.live.check()
 	v := reflect.ValueOf(val)
 
 	switch v.Kind() {
@@ -40,6 +49,10 @@ func (s *varStore) Set(path string, val interface{}) bool {
 		return s.SetInt(path, v.Int())
 	case reflect.Uint, reflect.Uint64, reflect.Uint8, reflect.Uint16, reflect.Uint32:
 		return s.SetInt(path, int64(v.Uint()))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return s.SetBytes(path, v.Bytes())
+		}
 	}
 
 	cPath := getCStringFromCache(path)
@@ -49,17 +62,46 @@ func (s *varStore) Set(path string, val interface{}) bool {
 }
 
 func (s *varStore) SetString(path, val string) bool {
+	s.live.check()
 	cPath := getCStringFromCache(path)
 	cVal := cString(val)
 	s.pointers = append(s.pointers, unsafe.Pointer(cVal))
 	return spSetString(s.ptr, cPath, unsafe.Pointer(cVal), len(val))
 }
 
+// SetBytes copies val to C memory and sets it by path.
+// Unlike SetString, val can contain zero bytes.
+func (s *varStore) SetBytes(path string, val []byte) bool {
+	s.live.check()
+	cPath := getCStringFromCache(path)
+	cVal := cBytes(val)
+	s.pointers = append(s.pointers, cVal)
+	return spSetString(s.ptr, cPath, cVal, len(val))
+}
+
+// SetBytesPinned sets val by path without copying, val is pinned until unpin or Free call.
+// Empty val is copied, because sophia calculates size of zero-sized field with strlen.
+// Pinned memory of a store, which is garbage collected, makes the Go runtime panic.
+func (s *varStore) SetBytesPinned(path string, val []byte) bool {
+	s.live.check()
+	if len(val) == 0 {
+		return s.SetBytes(path, val)
+	}
+	if s.pinner == nil {
+		s.pinner = new(runtime.Pinner)
+	}
+	ptr := unsafe.Pointer(unsafe.SliceData(val))
+	s.pinner.Pin(ptr)
+	return spSetString(s.ptr, getCStringFromCache(path), ptr, len(val))
+}
+
 func (s *varStore) SetInt(path string, val int64) bool {
+	s.live.check()
 	return spSetInt(s.ptr, getCStringFromCache(path), val)
 }
 
 func (s *varStore) Get(path string, size *int) unsafe.Pointer {
+	s.live.check()
 	return spGetString(s.ptr, getCStringFromCache(path), size)
 }
 
@@ -68,16 +110,21 @@ func (s *varStore) Get(path string, size *int) unsafe.Pointer {
 // C memory will be freed on Document Destroy() call.
 // So for long-term usage you should to make copy of string to avoid data corruption.
 func (s *varStore) GetString(path string, size *int) string {
+	s.live.check()
 	ptr := spGetString(s.ptr, getCStringFromCache(path), size)
-	sh := reflect.StringHeader{Data: uintptr(ptr), Len: *size}
-	return *(*string)(unsafe.Pointer(&sh))
+	if ptr == nil {
+		return ""
+	}
+	return unsafe.String((*byte)(ptr), *size)
 }
 
 func (s *varStore) GetObject(path string) unsafe.Pointer {
+	s.live.check()
 	return spGetObject(s.ptr, getCStringFromCache(path))
 }
 
 func (s *varStore) GetInt(path string) int64 {
+	s.live.check()
 	return spGetInt(s.ptr, getCStringFromCache(path))
 }
 
@@ -88,4 +135,13 @@ func (s *varStore) Free() {
 		free(f)
 	}
 	s.pointers = s.pointers[:0]
+	s.unpin()
+}
+
+// unpin releases memory pinned by SetBytesPinned.
+// It is called as soon as the document is consumed by sophia, which copies values of fields.
+func (s *varStore) unpin() {
+	if s.pinner != nil {
+		s.pinner.Unpin()
+	}
 }
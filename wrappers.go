@@ -1,6 +1,7 @@
 package sophia
 
 import (
+	"runtime/cgo"
 	"unsafe"
 )
 
@@ -25,9 +26,15 @@ extern void    *sp_cursor(void*);
 extern void    *sp_begin(void*);
 extern int      sp_prepare(void*);
 extern int      sp_commit(void*);
+static void    *sp_handle_ptr(uintptr_t handle) { return (void *)handle; }
 */
 import "C"
 
+// handlePointer converts cgo.Handle to a pointer, which can be passed as callback argument
+func handlePointer(handle cgo.Handle) unsafe.Pointer {
+	return C.sp_handle_ptr(C.uintptr_t(handle))
+}
+
 // spDestroy wrapper for sp_destroy
 // destroys C sophia object
 func spDestroy(p unsafe.Pointer) bool {
@@ -51,6 +58,12 @@ func spSetInt(obj unsafe.Pointer, path *C.char, val int64) bool {
 	return C.sp_setint(obj, path, C.int64_t(val)) == 0
 }
 
+// spCall wrapper for sp_setint which is used for calling sophia functions
+// returns result of the function
+func spCall(obj unsafe.Pointer, path *C.char) int {
+	return int(C.sp_setint(obj, path, 0))
+}
+
 // spGetInt wrapper for sp_getint
 func spGetInt(obj unsafe.Pointer, path *C.char) int64 {
 	ptr := C.sp_getint(obj, path)
@@ -102,6 +115,11 @@ func spDelete(obj, doc unsafe.Pointer) bool {
 	return C.sp_delete(obj, doc) == 0
 }
 
+// spPrepare wrapper for sp_prepare
+func spPrepare(tx unsafe.Pointer) int {
+	return int(C.sp_prepare(tx))
+}
+
 // spCommit wrapper for sp_commit
 func spCommit(tx unsafe.Pointer) int {
 	return int(C.sp_commit(tx))
@@ -123,3 +141,17 @@ func goString(ptr unsafe.Pointer) string {
 func cString(str string) *C.char {
 	return C.CString(str)
 }
+
+// cBytes copies bytes to C memory.
+// Empty slice is copied as empty C string,
+// because sophia calculates size of zero-sized field with strlen.
+func cBytes(b []byte) unsafe.Pointer {
+	if len(b) == 0 {
+		return unsafe.Pointer(C.CString(""))
+	}
+	return C.CBytes(b)
+}
+
+func goBytes(ptr unsafe.Pointer, size int) []byte {
+	return C.GoBytes(ptr, C.int(size))
+}